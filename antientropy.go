@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//反熵修复，节点故障、网络分区或者写入副本失败之后，副本之间的数据会出现差异，
+//节点定期与其他节点比较双方都是副本的键：按照键的哈希将这些键分为merkleLeaves个范围，每个范围的哈希由其中的键、版本与值计算，
+//再逐层合并为一棵Merkle树，比较两棵树时从根节点开始只进入哈希不同的子树，最后只交换不同范围中的键，
+//按照版本修复，对方版本更新的键从对方拉取，自己版本更新的键推送给对方，一方没有的键版本视为0。
+//多副本的组在删除键时会保留带版本的删除标记，删除标记同样参与比较，版本更新的删除会删除对方的旧数据，
+//因此被删除的键不会被其他副本恢复。只比较带版本的数据，从getter加载的数据不参与比较
+
+// Merkle树的叶子数量，即键被划分的范围数量
+const merkleLeaves = 256
+
+// ErrAntiEntropyRunning 已经有一轮反熵修复正在进行
+var ErrAntiEntropyRunning = errors.New("anti-entropy already running")
+
+// MerkleEntry 范围中的一个键
+type MerkleEntry struct {
+	Key     string
+	Version uint64
+	Hash    uint64 // 键、版本与值的哈希
+	Deleted bool   `json:",omitempty"` // 是否为删除标记
+}
+
+// AntiEntropyStatus 反熵修复的状态
+type AntiEntropyStatus struct {
+	Running        bool
+	Rounds         int64         // 已经完成的轮数
+	LastStart      time.Time     `json:",omitempty"`
+	LastDuration   time.Duration // 最近一轮的耗时
+	TreesCompared  int           // 最近一轮比较的树的数量，每个组与每个节点各一棵
+	RangesDiffered int           // 最近一轮中不同的范围数量
+	KeysRepaired   int           // 最近一轮修复的键数量
+	TotalRepaired  int64         // 所有轮次修复的键数量
+	LastError      string        `json:",omitempty"`
+}
+
+// 反熵修复的运行状态
+type antiEntropy struct {
+	running sync.Mutex // 正在进行一轮修复时持有
+	mu      sync.Mutex
+	status  AntiEntropyStatus
+}
+
+// 计算键被划分到的范围
+func merkleLeaf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % merkleLeaves)
+}
+
+func merkleEntry(key string, value ByteView) MerkleEntry {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write(binary.BigEndian.AppendUint64(nil, value.v))
+	h.Write(value.b)
+	return MerkleEntry{Key: key, Version: value.v, Hash: h.Sum64()}
+}
+
+func merkleTombstone(key string, version uint64) MerkleEntry {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write(binary.BigEndian.AppendUint64(nil, version))
+	h.Write([]byte{0})
+	return MerkleEntry{Key: key, Version: version, Hash: h.Sum64(), Deleted: true}
+}
+
+// 获得组中filter返回true的带版本的数据与删除标记，按照范围划分
+func (g *Group) merkleRanges(filter func(key string) bool) [][]MerkleEntry {
+	ranges := make([][]MerkleEntry, merkleLeaves)
+	for key, value := range g.mainCache.versioned() {
+		if filter(key) {
+			i := merkleLeaf(key)
+			ranges[i] = append(ranges[i], merkleEntry(key, value))
+		}
+	}
+	for key, version := range g.mainCache.tombstones() {
+		if filter(key) {
+			i := merkleLeaf(key)
+			ranges[i] = append(ranges[i], merkleTombstone(key, version))
+		}
+	}
+	return ranges
+}
+
+// 由各个范围构建Merkle树，树以数组的形式保存，节点i的子节点为2i+1与2i+2，最后merkleLeaves个节点为叶子
+func merkleTree(ranges [][]MerkleEntry) []uint64 {
+	tree := make([]uint64, 2*merkleLeaves-1)
+	for i, entries := range ranges {
+		// 同一个范围中键的顺序不固定，使用异或合并
+		for _, e := range entries {
+			tree[merkleLeaves-1+i] ^= e.Hash
+		}
+	}
+	for i := merkleLeaves - 2; i >= 0; i-- {
+		h := fnv.New64a()
+		h.Write(binary.BigEndian.AppendUint64(nil, tree[2*i+1]))
+		h.Write(binary.BigEndian.AppendUint64(nil, tree[2*i+2]))
+		tree[i] = h.Sum64()
+	}
+	return tree
+}
+
+// 从根节点开始比较两棵树，返回哈希不同的范围
+func diffRanges(a, b []uint64) []int {
+	if len(a) != len(b) {
+		return nil
+	}
+	var res []int
+	var walk func(i int)
+	walk = func(i int) {
+		if a[i] == b[i] {
+			return
+		}
+		if i >= merkleLeaves-1 {
+			res = append(res, i-(merkleLeaves-1))
+			return
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return res
+}
+
+// 选择自己与peer都是副本的键
+func (p *HTTPPool) sharedKeys(peer string, replicas int) func(key string) bool {
+	return func(key string) bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.peers == nil {
+			return false
+		}
+		nodes := p.peers.GetN(key, replicas)
+		return slices.Contains(nodes, p.self) && slices.Contains(nodes, peer)
+	}
+}
+
+// 选择组中自己与peer都是副本的键的范围，组不存在或者只有一个副本时返回false
+func (p *HTTPPool) localRanges(group string, peer string) ([][]MerkleEntry, bool) {
+	g := GetGroup(group)
+	if g == nil || g.replicas <= 1 {
+		return nil, false
+	}
+	return g.merkleRanges(p.sharedKeys(peer, g.replicas)), true
+}
+
+// AntiEntropy 与所有其他节点进行一轮反熵修复，已经有一轮正在进行时返回ErrAntiEntropyRunning
+func (p *HTTPPool) AntiEntropy(ctx context.Context) (AntiEntropyStatus, error) {
+	if !p.ae.running.TryLock() {
+		return p.AntiEntropyStatus(), ErrAntiEntropyRunning
+	}
+	defer p.ae.running.Unlock()
+	start := time.Now()
+	p.ae.mu.Lock()
+	p.ae.status.Running = true
+	p.ae.status.LastStart = start
+	p.ae.mu.Unlock()
+
+	trees, differed, repaired := 0, 0, 0
+	var lastErr error
+	for _, name := range GetGroupList() {
+		g := GetGroup(name)
+		if g == nil || g.replicas <= 1 {
+			continue
+		}
+		for _, peer := range p.otherPeers() {
+			d, r, err := p.repairWith(ctx, g, peer)
+			trees++
+			differed += d
+			repaired += r
+			if err != nil {
+				lastErr = fmt.Errorf("group %s with %s: %v", name, peer.BaseURL, err)
+				p.Log("anti-entropy failed: %v", lastErr)
+			}
+		}
+	}
+
+	p.ae.mu.Lock()
+	defer p.ae.mu.Unlock()
+	s := &p.ae.status
+	s.Running = false
+	s.Rounds++
+	s.LastDuration = time.Since(start)
+	s.TreesCompared = trees
+	s.RangesDiffered = differed
+	s.KeysRepaired = repaired
+	s.TotalRepaired += int64(repaired)
+	s.LastError = ""
+	if lastErr != nil {
+		s.LastError = lastErr.Error()
+	}
+	return *s, nil
+}
+
+// AntiEntropyStatus 获得反熵修复的状态
+func (p *HTTPPool) AntiEntropyStatus() AntiEntropyStatus {
+	p.ae.mu.Lock()
+	defer p.ae.mu.Unlock()
+	return p.ae.status
+}
+
+// StartAntiEntropy 每隔interval进行一轮反熵修复，直到ctx被取消
+func (p *HTTPPool) StartAntiEntropy(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_, _ = p.AntiEntropy(ctx)
+			}
+		}
+	}()
+}
+
+// 比较组在自己与peer上的数据，返回不同的范围数量与修复的键数量
+func (p *HTTPPool) repairWith(ctx context.Context, g *Group, peer *HttpGetter) (int, int, error) {
+	ranges := g.merkleRanges(p.sharedKeys(peer.BaseURL, g.replicas))
+	var remote []uint64
+	if err := peer.MerkleTree(ctx, g.name, p.self, &remote); err != nil {
+		return 0, 0, err
+	}
+	diff := diffRanges(merkleTree(ranges), remote)
+	if len(diff) == 0 {
+		return 0, 0, nil
+	}
+	var entries []MerkleEntry
+	if err := peer.MerkleRanges(ctx, g.name, p.self, diff, &entries); err != nil {
+		return len(diff), 0, err
+	}
+	theirs := make(map[string]MerkleEntry, len(entries))
+	for _, e := range entries {
+		theirs[e.Key] = e
+	}
+	ours := make(map[string]MerkleEntry)
+	for _, i := range diff {
+		for _, e := range ranges[i] {
+			ours[e.Key] = e
+		}
+	}
+	repaired := 0
+	var lastErr error
+	// 对方版本更新的键从对方拉取或者在本地删除
+	for key, t := range theirs {
+		if ours[key].Version >= t.Version {
+			continue
+		}
+		if t.Deleted {
+			if _, ok := g.deleteVersion(key, t.Version); ok {
+				repaired++
+			}
+			continue
+		}
+		v, err := g.requestPeer(ctx, peer, key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if v.v != 0 && g.setVersioned(key, v) {
+			repaired++
+		}
+	}
+	// 自己版本更新的键或者删除推送给对方
+	for key, o := range ours {
+		if theirs[key].Version >= o.Version {
+			continue
+		}
+		var err error
+		if o.Deleted {
+			err = peer.Invalidate(ctx, []Invalidation{{ID: invalidations.nextID(), Group: g.name, Key: key, Version: o.Version}})
+		} else {
+			v, ok := g.mainCache.get(key)
+			if !ok || v.v != o.Version {
+				continue
+			}
+			err = g.setReplica(ctx, peer, key, v)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		repaired++
+	}
+	return len(diff), repaired, lastErr
+}
+
+// MerkleTree 获得远程节点上组中与peer共享的键的Merkle树
+func (h *HttpGetter) MerkleTree(ctx context.Context, group string, peer string, out *[]uint64) error {
+	u := fmt.Sprintf("%v/MerkleTree?group=%v&peer=%v", h.BaseURL, url.QueryEscape(group), url.QueryEscape(peer))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	return h.doJSON(req, out)
+}
+
+// MerkleRanges 获得远程节点上组中与peer共享的键在指定范围中的数据
+func (h *HttpGetter) MerkleRanges(ctx context.Context, group string, peer string, ranges []int, out *[]MerkleEntry) error {
+	s := make([]string, len(ranges))
+	for i, r := range ranges {
+		s[i] = strconv.Itoa(r)
+	}
+	u := fmt.Sprintf("%v/MerkleRanges?group=%v&peer=%v&ranges=%v",
+		h.BaseURL, url.QueryEscape(group), url.QueryEscape(peer), strings.Join(s, ","))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	return h.doJSON(req, out)
+}
+
+// 解析以逗号分隔的范围列表
+func parseRanges(s string) ([]int, error) {
+	var res []int
+	for _, part := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || i >= merkleLeaves {
+			return nil, fmt.Errorf("invalid range: %q", part)
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
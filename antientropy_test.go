@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDiffRanges(t *testing.T) {
+	a := make([][]MerkleEntry, merkleLeaves)
+	b := make([][]MerkleEntry, merkleLeaves)
+	for _, key := range []string{"k1", "k2", "k3"} {
+		e := merkleEntry(key, ByteView{b: []byte(key), v: 1})
+		a[merkleLeaf(key)] = append(a[merkleLeaf(key)], e)
+		b[merkleLeaf(key)] = append(b[merkleLeaf(key)], e)
+	}
+	if diff := diffRanges(merkleTree(a), merkleTree(b)); len(diff) != 0 {
+		t.Fatalf("expect no difference, got %v", diff)
+	}
+	i := merkleLeaf("k2")
+	b[i] = []MerkleEntry{merkleEntry("k2", ByteView{b: []byte("new"), v: 2})}
+	if diff := diffRanges(merkleTree(a), merkleTree(b)); len(diff) != 1 || diff[0] != i {
+		t.Fatalf("expect range %d to differ, got %v", i, diff)
+	}
+}
+
+func TestAntiEntropy(t *testing.T) {
+	name := "anti-entropy-test"
+	g := NewGroupWithInfo(GroupInfo{Name: name, CacheBytes: 4096, Replicas: 2}, nil)
+	defer DeleteGroup(name)
+
+	// 远程节点在内存中保存带版本的数据与删除标记
+	mu := sync.Mutex{}
+	data := map[string]ByteView{}
+	tombs := map[string]uint64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ranges := make([][]MerkleEntry, merkleLeaves)
+		for key, v := range data {
+			ranges[merkleLeaf(key)] = append(ranges[merkleLeaf(key)], merkleEntry(key, v))
+		}
+		for key, v := range tombs {
+			ranges[merkleLeaf(key)] = append(ranges[merkleLeaf(key)], merkleTombstone(key, v))
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/MerkleTree":
+			writeJSON(w, merkleTree(ranges))
+		case r.URL.Path == "/MerkleRanges":
+			list, _ := parseRanges(r.URL.Query().Get("ranges"))
+			entries := []MerkleEntry{}
+			for _, i := range list {
+				entries = append(entries, ranges[i]...)
+			}
+			writeJSON(w, entries)
+		case r.URL.Path == "/InvalidateBatch":
+			var batch []Invalidation
+			_ = json.Unmarshal(body, &batch)
+			for _, inv := range batch {
+				if data[inv.Key].v < inv.Version {
+					delete(data, inv.Key)
+					tombs[inv.Key] = inv.Version
+				}
+			}
+		case r.Method == http.MethodGet:
+			v := data[r.URL.Query().Get("key")]
+			body, _ := proto.Marshal(&cachepb.Response{Value: v.b, Version: v.v})
+			_, _ = w.Write(body)
+		default:
+			req := cachepb.SetRequest{}
+			_ = proto.Unmarshal(body, &req)
+			data[req.Key] = ByteView{b: req.Value, v: req.Version}
+			delete(tombs, req.Key)
+			body, _ = proto.Marshal(&cachepb.Response{})
+			_, _ = w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	// k1在远程的版本更新，k4在本地的版本更新，本地删除了k2，远程删除了k3，k5只在本地，k6只在远程
+	g.setVersioned("k1", ByteView{b: []byte("old"), v: 1})
+	g.setVersioned("k2", ByteView{b: []byte("v2"), v: 1})
+	g.setVersioned("k3", ByteView{b: []byte("v3"), v: 1})
+	g.setVersioned("k4", ByteView{b: []byte("new4"), v: 2})
+	g.setVersioned("k5", ByteView{b: []byte("v5"), v: 1})
+	data["k1"] = ByteView{b: []byte("new"), v: 2}
+	data["k2"] = ByteView{b: []byte("v2"), v: 1}
+	data["k4"] = ByteView{b: []byte("old4"), v: 1}
+	data["k6"] = ByteView{b: []byte("v6"), v: 1}
+	tombs["k3"] = 2
+	if ok, err := g.Delete("k2"); !ok || err != nil {
+		t.Fatalf("delete k2 failed: %v %v", ok, err)
+	}
+
+	p := NewHTTPPool("http://self")
+	p.Set("http://self", srv.URL)
+	status, err := p.AntiEntropy(context.Background())
+	if err != nil || status.LastError != "" {
+		t.Fatalf("anti-entropy failed: %v %+v", err, status)
+	}
+	if status.KeysRepaired != 6 || status.TreesCompared != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if v, _ := g.mainCache.get("k1"); v.String() != "new" {
+		t.Fatal("newer remote value not pulled")
+	}
+	if data["k4"].String() != "new4" {
+		t.Fatal("newer local value not pushed")
+	}
+	if v, _ := g.mainCache.get("k6"); v.String() != "v6" {
+		t.Fatal("remote only key not pulled")
+	}
+	if data["k5"].String() != "v5" {
+		t.Fatal("local only key not pushed")
+	}
+	// 被删除的键不会被复制回来，删除同样会被修复
+	if _, ok := g.mainCache.get("k2"); ok {
+		t.Fatal("deleted key restored from remote")
+	}
+	if _, ok := data["k2"]; ok || tombs["k2"] == 0 {
+		t.Fatal("local delete not pushed")
+	}
+	if _, ok := g.mainCache.get("k3"); ok {
+		t.Fatal("remote delete not applied")
+	}
+
+	// 之后的修复不会再改变任何数据
+	status, _ = p.AntiEntropy(context.Background())
+	if status.KeysRepaired != 0 || status.TotalRepaired != 6 {
+		t.Fatalf("expect nothing to repair, got %+v", status)
+	}
+	if _, ok := g.mainCache.get("k2"); ok {
+		t.Fatal("deleted key restored from remote")
+	}
+}
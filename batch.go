@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//将一段时间内并发未命中的键合并为一次对BatchGetter的调用
+
+const (
+	defaultBatchWindow = 2 * time.Millisecond
+	defaultBatchSize   = 100
+)
+
+// BatchGetter 支持一次获取多个键的Getter，返回结果中不存在的键视为未找到
+type BatchGetter interface {
+	Getter
+	GetBatch(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+type batchResult struct {
+	value []byte
+	err   error
+}
+
+// 正在等待发送的一批键
+type batch struct {
+	waiters    map[string][]chan batchResult
+	deadline   time.Time // 所有等待者中最晚的截止时间
+	noDeadline bool      // 是否有等待者没有截止时间
+}
+
+type batcher struct {
+	getter BatchGetter
+	window time.Duration //收集键的时间窗口
+	size   int           //一批键的最大数量，达到后立即发送
+	mu     sync.Mutex
+	cur    *batch
+}
+
+func newBatcher(getter BatchGetter, window time.Duration, size int) *batcher {
+	if window <= 0 {
+		window = defaultBatchWindow
+	}
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	return &batcher{getter: getter, window: window, size: size}
+}
+
+// 将key加入当前批次并等待结果，ctx被取消时立即返回，但不会影响批次中的其他键
+func (b *batcher) get(ctx context.Context, key string) ([]byte, error) {
+	ch := make(chan batchResult, 1)
+	b.mu.Lock()
+	if b.cur == nil {
+		b.cur = &batch{waiters: make(map[string][]chan batchResult)}
+		cur := b.cur
+		time.AfterFunc(b.window, func() { b.flush(cur) })
+	}
+	cur := b.cur
+	cur.waiters[key] = append(cur.waiters[key], ch)
+	if deadline, ok := ctx.Deadline(); !ok {
+		cur.noDeadline = true
+	} else if deadline.After(cur.deadline) {
+		cur.deadline = deadline
+	}
+	full := len(cur.waiters) >= b.size
+	b.mu.Unlock()
+	if full {
+		go b.flush(cur)
+	}
+	select {
+	case r := <-ch:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 发送批次中的所有键，并将结果分发给等待者，每个批次只会被发送一次
+func (b *batcher) flush(cur *batch) {
+	b.mu.Lock()
+	if b.cur != cur {
+		b.mu.Unlock()
+		return
+	}
+	b.cur = nil
+	b.mu.Unlock()
+
+	keys := make([]string, 0, len(cur.waiters))
+	for key := range cur.waiters {
+		keys = append(keys, key)
+	}
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if !cur.noDeadline {
+		ctx, cancel = context.WithDeadline(ctx, cur.deadline)
+	}
+	defer cancel()
+	values, err := b.getter.GetBatch(ctx, keys)
+	for key, chs := range cur.waiters {
+		r := batchResult{err: err}
+		if err == nil {
+			if v, ok := values[key]; ok {
+				r.value = v
+			} else {
+				r.err = fmt.Errorf("key %s: %w", key, ErrNotFound)
+			}
+		}
+		for _, ch := range chs {
+			ch <- r
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type countingBatchGetter struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (c *countingBatchGetter) Get(key string) ([]byte, error) {
+	return []byte(key), nil
+}
+
+func (c *countingBatchGetter) GetBatch(_ context.Context, keys []string) (map[string][]byte, error) {
+	c.mu.Lock()
+	c.batches = append(c.batches, keys)
+	c.mu.Unlock()
+	res := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if key != "missing" {
+			res[key] = []byte("v-" + key)
+		}
+	}
+	return res, nil
+}
+
+func TestBatchGetter(t *testing.T) {
+	getter := &countingBatchGetter{}
+	g := NewGroupWithInfo(GroupInfo{Name: "batch-test", CacheBytes: 4096, BatchWindow: 20}, getter)
+	defer DeleteGroup(g.name)
+	keys := []string{"a", "b", "c", "d", "missing"}
+	res, err := g.GetMulti(context.Background(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 4 || res["c"].String() != "v-c" {
+		t.Fatalf("unexpected result %v", res)
+	}
+	if len(getter.batches) != 1 || len(getter.batches[0]) != len(keys) {
+		t.Fatalf("expect all misses in one batch, got %v", getter.batches)
+	}
+	if _, err := g.Get("missing"); err == nil {
+		t.Fatalf("expect error for missing key")
+	}
+}
@@ -1,10 +1,15 @@
 package cache
 
+import "time"
+
 //缓存值的抽象与封装
 
 // ByteView 表示缓存值，是一个只读的数据结构
 type ByteView struct {
 	b []byte
+	e time.Time // 过期时间，零值表示永不过期
+	s time.Time // 软过期时间，超过之后数据仍然可以使用，但是需要在后台刷新，零值表示没有软过期
+	v uint64    // 写入时生成的版本，用于比较多个副本中数据的新旧，0表示没有版本(从getter加载的数据)
 }
 
 func (b ByteView) Len() int {
@@ -21,6 +26,26 @@ func (b ByteView) String() string {
 	return string(b.b)
 }
 
+// Expire 返回缓存值的过期时间，零值表示永不过期
+func (b ByteView) Expire() time.Time {
+	return b.e
+}
+
+// Version 返回缓存值的版本，0表示没有版本
+func (b ByteView) Version() uint64 {
+	return b.v
+}
+
+// 判断缓存值在now时刻是否已经过期
+func (b ByteView) expired(now time.Time) bool {
+	return !b.e.IsZero() && now.After(b.e)
+}
+
+// 判断缓存值在now时刻是否已经软过期
+func (b ByteView) stale(now time.Time) bool {
+	return !b.s.IsZero() && now.After(b.s)
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
@@ -3,8 +3,17 @@ package cache
 import (
 	"cache/lru"
 	"encoding/json"
-	"os"
+	"io"
+	"maps"
 	"sync"
+	"time"
+)
+
+const (
+	// 删除标记的最长保留时间，超过之后其他副本上还没有被删除的数据可能被反熵修复恢复
+	tombstoneTTL = 24 * time.Hour
+	// 每个组最多保留的删除标记数量，超过之后丢弃最早的标记
+	maxTombstones = 1 << 16
 )
 
 // 用于并发控制
@@ -12,14 +21,25 @@ type cache struct {
 	mu         sync.Mutex
 	lru        *lru.Cache
 	cacheBytes int64
+	onEvicted  func(key string, value lru.Value) //数据被淘汰时的回调函数，在持有锁的情况下调用
+	// 被删除的键的删除标记，反熵修复时用来区分被删除的键与对方没有收到的键，为nil时不记录
+	tombs map[string]tombstone
+}
+
+// 删除标记，记录删除时的版本
+type tombstone struct {
+	v  uint64
+	at time.Time
 }
 
 func (c *cache) add(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		c.lru = lru.New(c.cacheBytes, c.onEvicted)
 	}
+	observeVersion(value.v)
+	delete(c.tombs, key)
 	c.lru.Add(key, value)
 }
 
@@ -28,13 +48,107 @@ func (c *cache) addList(keys []string, values []ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		c.lru = lru.New(c.cacheBytes, c.onEvicted)
 	}
 	for i, _ := range keys {
+		observeVersion(values[i].v)
+		delete(c.tombs, keys[i])
 		c.lru.Add(keys[i], values[i])
 	}
 }
 
+// 只在key不存在时添加，返回内存中的数据以及是否添加
+func (c *cache) addIfAbsent(key string, value ByteView) (ByteView, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		c.lru = lru.New(c.cacheBytes, c.onEvicted)
+	}
+	if v, ok := c.lru.Get(key); ok && !v.(ByteView).expired(time.Now()) {
+		return v.(ByteView), false
+	}
+	observeVersion(value.v)
+	c.lru.Add(key, value)
+	return value, true
+}
+
+// 只在key不存在或者已有数据的版本比value旧时添加，返回是否添加
+func (c *cache) addIfNewer(key string, value ByteView) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		c.lru = lru.New(c.cacheBytes, c.onEvicted)
+	}
+	if v, ok := c.lru.Get(key); ok && !v.(ByteView).expired(time.Now()) && v.(ByteView).v >= value.v {
+		return false
+	}
+	// 在这个版本之后已经被删除
+	if t, ok := c.tombs[key]; ok && t.v >= value.v {
+		return false
+	}
+	observeVersion(value.v)
+	delete(c.tombs, key)
+	c.lru.Add(key, value)
+	return true
+}
+
+// 在key已有的数据与删除标记都比version旧时删除key，并记录删除标记，返回是否删除了数据以及删除是否生效
+func (c *cache) deleteVersion(key string, version uint64) (deleted bool, applied bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	observeVersion(version)
+	if t, ok := c.tombs[key]; ok && t.v >= version {
+		return false, false
+	}
+	if c.lru != nil {
+		if v, ok := c.lru.Get(key); ok {
+			if b := v.(ByteView); !b.expired(time.Now()) && b.v >= version {
+				return false, false
+			}
+			deleted = c.lru.Delete(key)
+		}
+	}
+	if c.tombs != nil {
+		c.addTombstone(key, version)
+	}
+	return deleted, true
+}
+
+// 记录删除标记，数量达到上限时先丢弃过期的标记，仍然超过时丢弃最早的标记，在持有锁的情况下调用
+func (c *cache) addTombstone(key string, version uint64) {
+	now := time.Now()
+	if _, ok := c.tombs[key]; !ok && len(c.tombs) >= maxTombstones {
+		oldest := ""
+		for k, t := range c.tombs {
+			if now.Sub(t.at) > tombstoneTTL {
+				delete(c.tombs, k)
+			} else if oldest == "" || t.at.Before(c.tombs[oldest].at) {
+				oldest = k
+			}
+		}
+		if len(c.tombs) >= maxTombstones {
+			delete(c.tombs, oldest)
+		}
+	}
+	c.tombs[key] = tombstone{v: version, at: now}
+}
+
+// 获得未过期的删除标记的版本，同时丢弃过期的标记
+func (c *cache) tombstones() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	res := make(map[string]uint64, len(c.tombs))
+	for key, t := range c.tombs {
+		if now.Sub(t.at) > tombstoneTTL {
+			delete(c.tombs, key)
+			continue
+		}
+		res[key] = t.v
+	}
+	return res
+}
+
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -42,6 +156,11 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 		return
 	}
 	if v, ok := c.lru.Get(key); ok {
+		// 已经过期的数据直接删除，视为未命中
+		if v.(ByteView).expired(time.Now()) {
+			c.lru.Delete(key)
+			return ByteView{}, false
+		}
 		return v.(ByteView), ok
 	}
 	return
@@ -49,37 +168,129 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 
 // 获取所有的键列表
 func (c *cache) getKeyList() []string {
-	mu.Lock()
-	defer mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.lru == nil {
 		return nil
 	}
 	return c.lru.GetKeyList()
 }
 
-// 通过json序列化来将缓存中的数据进行持久化保存
-func (c *cache) saveCache(f *os.File, info *GroupInfo) error {
+// 获取所有未过期并且带版本的数据，用于副本之间的比较
+func (c *cache) versioned() map[string]ByteView {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	e := json.NewEncoder(f)
+	res := make(map[string]ByteView)
 	if c.lru == nil {
-		return nil
+		return res
+	}
+	now := time.Now()
+	for _, e := range c.lru.GetKVList() {
+		if b := e.Value.(ByteView); b.v != 0 && !b.expired(now) {
+			res[e.Key] = b
+		}
+	}
+	return res
+}
+
+// 获得所有未过期的数据，按照从最久未使用到最近使用的顺序排列，依次添加即可还原LRU中的顺序
+func (c *cache) entries() ([]string, []ByteView) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil, nil
 	}
 	list := c.lru.GetKVList()
-	info.Num = len(list)
+	now := time.Now()
+	keys := make([]string, 0, len(list))
+	values := make([]ByteView, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		if b := list[i].Value.(ByteView); !b.expired(now) {
+			keys = append(keys, list[i].Key)
+			values = append(values, b)
+		}
+	}
+	return keys, values
+}
+
+// 复制删除标记到另一个缓存中，两个缓存都记录删除标记时才复制
+func (c *cache) copyTombstones(to *cache) {
+	c.mu.Lock()
+	tombs := maps.Clone(c.tombs)
+	c.mu.Unlock()
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	if to.tombs == nil {
+		return
+	}
+	maps.Copy(to.tombs, tombs)
+}
+
+// 通过json序列化来将缓存中的数据进行持久化保存，键值对按照从最久未使用到最近使用的顺序写入，
+// 加载时依次添加即可还原LRU中的顺序，filter不为nil时只保存filter返回true的键
+func (c *cache) saveCache(w io.Writer, info *GroupInfo, filter func(key string) bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := json.NewEncoder(w)
+	var list []lru.Entry
+	if c.lru != nil {
+		list = c.lru.GetKVList()
+	}
+	now := time.Now()
+	entries := make([]PersistenceType, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		b := list[i].Value.(ByteView)
+		if b.expired(now) || (filter != nil && !filter(list[i].Key)) {
+			continue
+		}
+		entries = append(entries, ByteToPersistence(list[i].Key, &b))
+	}
+	info.Num = len(entries)
 	if err := e.Encode(info); err != nil {
 		return err
 	}
-	for _, v := range list {
-		b := v.Value.(ByteView)
-		p := ByteToPersistence(v.Key, &b)
-		if err := e.Encode(&p); err != nil {
+	for i := range entries {
+		if err := e.Encode(&entries[i]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// 获得当前使用的内存以及键值对的数量
+func (c *cache) stats() (bytes int64, items int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Bytes(), int64(c.lru.Len())
+}
+
+// 获得缓存的最大内存
+func (c *cache) maxBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cacheBytes
+}
+
+// 修改缓存的最大内存，缩小时淘汰多出的数据
+func (c *cache) setCacheBytes(cacheBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cacheBytes = cacheBytes
+	if c.lru != nil {
+		c.lru.SetMaxBytes(cacheBytes)
+	}
+}
+
+// 清空缓存中的所有数据与删除标记
+func (c *cache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru = nil
+	if c.tombs != nil {
+		c.tombs = make(map[string]tombstone)
+	}
+}
+
 func (c *cache) delete(key string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
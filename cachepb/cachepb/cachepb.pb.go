@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.33.0
+// 	protoc-gen-go v1.36.6
 // 	protoc        v5.28.3
 // source: cachepb.proto
 
@@ -11,6 +11,7 @@ import (
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
 	sync "sync"
+	unsafe "unsafe"
 )
 
 const (
@@ -20,22 +21,72 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Consistency int32
+
+const (
+	Consistency_DEFAULT Consistency = 0
+	Consistency_ONE     Consistency = 1
+	Consistency_QUORUM  Consistency = 2
+	Consistency_ALL     Consistency = 3
+)
+
+// Enum value maps for Consistency.
+var (
+	Consistency_name = map[int32]string{
+		0: "DEFAULT",
+		1: "ONE",
+		2: "QUORUM",
+		3: "ALL",
+	}
+	Consistency_value = map[string]int32{
+		"DEFAULT": 0,
+		"ONE":     1,
+		"QUORUM":  2,
+		"ALL":     3,
+	}
+)
+
+func (x Consistency) Enum() *Consistency {
+	p := new(Consistency)
+	*p = x
+	return p
+}
+
+func (x Consistency) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Consistency) Descriptor() protoreflect.EnumDescriptor {
+	return file_cachepb_proto_enumTypes[0].Descriptor()
+}
+
+func (Consistency) Type() protoreflect.EnumType {
+	return &file_cachepb_proto_enumTypes[0]
+}
+
+func (x Consistency) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Consistency.Descriptor instead.
+func (Consistency) EnumDescriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{0}
+}
+
 type GetRequest struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Consistency   Consistency            `protobuf:"varint,3,opt,name=consistency,proto3,enum=Consistency" json:"consistency,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	Group string `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
-	Key   string `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetRequest) Reset() {
 	*x = GetRequest{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_cachepb_proto_msgTypes[0]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_cachepb_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *GetRequest) String() string {
@@ -46,7 +97,7 @@ func (*GetRequest) ProtoMessage() {}
 
 func (x *GetRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_cachepb_proto_msgTypes[0]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -75,23 +126,29 @@ func (x *GetRequest) GetKey() string {
 	return ""
 }
 
+func (x *GetRequest) GetConsistency() Consistency {
+	if x != nil {
+		return x.Consistency
+	}
+	return Consistency_DEFAULT
+}
+
 type SetRequest struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Consistency   Consistency            `protobuf:"varint,4,opt,name=consistency,proto3,enum=Consistency" json:"consistency,omitempty"`
+	Version       uint64                 `protobuf:"varint,5,opt,name=version,proto3" json:"version,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	Group string `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
-	Key   string `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
-	Value []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *SetRequest) Reset() {
 	*x = SetRequest{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_cachepb_proto_msgTypes[1]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_cachepb_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *SetRequest) String() string {
@@ -102,7 +159,7 @@ func (*SetRequest) ProtoMessage() {}
 
 func (x *SetRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_cachepb_proto_msgTypes[1]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -138,22 +195,33 @@ func (x *SetRequest) GetValue() []byte {
 	return nil
 }
 
+func (x *SetRequest) GetConsistency() Consistency {
+	if x != nil {
+		return x.Consistency
+	}
+	return Consistency_DEFAULT
+}
+
+func (x *SetRequest) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
 type DeleteRequest struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	Group string `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
-	Key   string `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *DeleteRequest) Reset() {
 	*x = DeleteRequest{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_cachepb_proto_msgTypes[2]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_cachepb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *DeleteRequest) String() string {
@@ -164,7 +232,7 @@ func (*DeleteRequest) ProtoMessage() {}
 
 func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_cachepb_proto_msgTypes[2]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -194,20 +262,18 @@ func (x *DeleteRequest) GetKey() string {
 }
 
 type Response struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Value         []byte                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Version       uint64                 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	Value []byte `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Response) Reset() {
 	*x = Response{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_cachepb_proto_msgTypes[3]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_cachepb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *Response) String() string {
@@ -218,7 +284,7 @@ func (*Response) ProtoMessage() {}
 
 func (x *Response) ProtoReflect() protoreflect.Message {
 	mi := &file_cachepb_proto_msgTypes[3]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -240,21 +306,25 @@ func (x *Response) GetValue() []byte {
 	return nil
 }
 
+func (x *Response) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
 type GroupList struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	GroupName     []string               `protobuf:"bytes,1,rep,name=group_name,json=groupName,proto3" json:"group_name,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	GroupName []string `protobuf:"bytes,1,rep,name=group_name,json=groupName,proto3" json:"group_name,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GroupList) Reset() {
 	*x = GroupList{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_cachepb_proto_msgTypes[4]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_cachepb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *GroupList) String() string {
@@ -265,7 +335,7 @@ func (*GroupList) ProtoMessage() {}
 
 func (x *GroupList) ProtoReflect() protoreflect.Message {
 	mi := &file_cachepb_proto_msgTypes[4]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -288,20 +358,17 @@ func (x *GroupList) GetGroupName() []string {
 }
 
 type GroupKeyList struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []string               `protobuf:"bytes,1,rep,name=key,proto3" json:"key,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	Key []string `protobuf:"bytes,1,rep,name=key,proto3" json:"key,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GroupKeyList) Reset() {
 	*x = GroupKeyList{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_cachepb_proto_msgTypes[5]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_cachepb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *GroupKeyList) String() string {
@@ -312,7 +379,7 @@ func (*GroupKeyList) ProtoMessage() {}
 
 func (x *GroupKeyList) ProtoReflect() protoreflect.Message {
 	mi := &file_cachepb_proto_msgTypes[5]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -334,59 +401,806 @@ func (x *GroupKeyList) GetKey() []string {
 	return nil
 }
 
-var File_cachepb_proto protoreflect.FileDescriptor
+type SnapshotInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Size          int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	ModTime       int64                  `protobuf:"varint,3,opt,name=mod_time,json=modTime,proto3" json:"mod_time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotInfo) Reset() {
+	*x = SnapshotInfo{}
+	mi := &file_cachepb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotInfo) ProtoMessage() {}
+
+func (x *SnapshotInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotInfo.ProtoReflect.Descriptor instead.
+func (*SnapshotInfo) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SnapshotInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SnapshotInfo) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *SnapshotInfo) GetModTime() int64 {
+	if x != nil {
+		return x.ModTime
+	}
+	return 0
+}
+
+type SnapshotList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Snapshots     []*SnapshotInfo        `protobuf:"bytes,1,rep,name=snapshots,proto3" json:"snapshots,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotList) Reset() {
+	*x = SnapshotList{}
+	mi := &file_cachepb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotList) ProtoMessage() {}
+
+func (x *SnapshotList) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotList.ProtoReflect.Descriptor instead.
+func (*SnapshotList) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SnapshotList) GetSnapshots() []*SnapshotInfo {
+	if x != nil {
+		return x.Snapshots
+	}
+	return nil
+}
+
+type GroupStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Gets          int64                  `protobuf:"varint,2,opt,name=gets,proto3" json:"gets,omitempty"`
+	CacheHits     int64                  `protobuf:"varint,3,opt,name=cache_hits,json=cacheHits,proto3" json:"cache_hits,omitempty"`
+	Loads         int64                  `protobuf:"varint,4,opt,name=loads,proto3" json:"loads,omitempty"`
+	LoadsDeduped  int64                  `protobuf:"varint,5,opt,name=loads_deduped,json=loadsDeduped,proto3" json:"loads_deduped,omitempty"`
+	PeerLoads     int64                  `protobuf:"varint,6,opt,name=peer_loads,json=peerLoads,proto3" json:"peer_loads,omitempty"`
+	PeerErrors    int64                  `protobuf:"varint,7,opt,name=peer_errors,json=peerErrors,proto3" json:"peer_errors,omitempty"`
+	LocalLoads    int64                  `protobuf:"varint,8,opt,name=local_loads,json=localLoads,proto3" json:"local_loads,omitempty"`
+	LocalLoadErrs int64                  `protobuf:"varint,9,opt,name=local_load_errs,json=localLoadErrs,proto3" json:"local_load_errs,omitempty"`
+	Evictions     int64                  `protobuf:"varint,10,opt,name=evictions,proto3" json:"evictions,omitempty"`
+	Bytes         int64                  `protobuf:"varint,11,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Items         int64                  `protobuf:"varint,12,opt,name=items,proto3" json:"items,omitempty"`
+	NegativeHits  int64                  `protobuf:"varint,13,opt,name=negative_hits,json=negativeHits,proto3" json:"negative_hits,omitempty"`
+	StaleHits     int64                  `protobuf:"varint,14,opt,name=stale_hits,json=staleHits,proto3" json:"stale_hits,omitempty"`
+	Refreshes     int64                  `protobuf:"varint,15,opt,name=refreshes,proto3" json:"refreshes,omitempty"`
+	Writes        int64                  `protobuf:"varint,16,opt,name=writes,proto3" json:"writes,omitempty"`
+	WriteErrors   int64                  `protobuf:"varint,17,opt,name=write_errors,json=writeErrors,proto3" json:"write_errors,omitempty"`
+	WritesDropped int64                  `protobuf:"varint,18,opt,name=writes_dropped,json=writesDropped,proto3" json:"writes_dropped,omitempty"`
+	PendingWrites int64                  `protobuf:"varint,19,opt,name=pending_writes,json=pendingWrites,proto3" json:"pending_writes,omitempty"`
+	Hedges        int64                  `protobuf:"varint,20,opt,name=hedges,proto3" json:"hedges,omitempty"`
+	PeerRetries   int64                  `protobuf:"varint,21,opt,name=peer_retries,json=peerRetries,proto3" json:"peer_retries,omitempty"`
+	ReadRepairs   int64                  `protobuf:"varint,22,opt,name=read_repairs,json=readRepairs,proto3" json:"read_repairs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GroupStats) Reset() {
+	*x = GroupStats{}
+	mi := &file_cachepb_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GroupStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupStats) ProtoMessage() {}
+
+func (x *GroupStats) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupStats.ProtoReflect.Descriptor instead.
+func (*GroupStats) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GroupStats) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *GroupStats) GetGets() int64 {
+	if x != nil {
+		return x.Gets
+	}
+	return 0
+}
+
+func (x *GroupStats) GetCacheHits() int64 {
+	if x != nil {
+		return x.CacheHits
+	}
+	return 0
+}
+
+func (x *GroupStats) GetLoads() int64 {
+	if x != nil {
+		return x.Loads
+	}
+	return 0
+}
+
+func (x *GroupStats) GetLoadsDeduped() int64 {
+	if x != nil {
+		return x.LoadsDeduped
+	}
+	return 0
+}
+
+func (x *GroupStats) GetPeerLoads() int64 {
+	if x != nil {
+		return x.PeerLoads
+	}
+	return 0
+}
+
+func (x *GroupStats) GetPeerErrors() int64 {
+	if x != nil {
+		return x.PeerErrors
+	}
+	return 0
+}
+
+func (x *GroupStats) GetLocalLoads() int64 {
+	if x != nil {
+		return x.LocalLoads
+	}
+	return 0
+}
+
+func (x *GroupStats) GetLocalLoadErrs() int64 {
+	if x != nil {
+		return x.LocalLoadErrs
+	}
+	return 0
+}
+
+func (x *GroupStats) GetEvictions() int64 {
+	if x != nil {
+		return x.Evictions
+	}
+	return 0
+}
+
+func (x *GroupStats) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *GroupStats) GetItems() int64 {
+	if x != nil {
+		return x.Items
+	}
+	return 0
+}
+
+func (x *GroupStats) GetNegativeHits() int64 {
+	if x != nil {
+		return x.NegativeHits
+	}
+	return 0
+}
+
+func (x *GroupStats) GetStaleHits() int64 {
+	if x != nil {
+		return x.StaleHits
+	}
+	return 0
+}
+
+func (x *GroupStats) GetRefreshes() int64 {
+	if x != nil {
+		return x.Refreshes
+	}
+	return 0
+}
+
+func (x *GroupStats) GetWrites() int64 {
+	if x != nil {
+		return x.Writes
+	}
+	return 0
+}
+
+func (x *GroupStats) GetWriteErrors() int64 {
+	if x != nil {
+		return x.WriteErrors
+	}
+	return 0
+}
+
+func (x *GroupStats) GetWritesDropped() int64 {
+	if x != nil {
+		return x.WritesDropped
+	}
+	return 0
+}
+
+func (x *GroupStats) GetPendingWrites() int64 {
+	if x != nil {
+		return x.PendingWrites
+	}
+	return 0
+}
+
+func (x *GroupStats) GetHedges() int64 {
+	if x != nil {
+		return x.Hedges
+	}
+	return 0
+}
+
+func (x *GroupStats) GetPeerRetries() int64 {
+	if x != nil {
+		return x.PeerRetries
+	}
+	return 0
+}
+
+func (x *GroupStats) GetReadRepairs() int64 {
+	if x != nil {
+		return x.ReadRepairs
+	}
+	return 0
+}
+
+type StatsList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Stats         []*GroupStats          `protobuf:"bytes,1,rep,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsList) Reset() {
+	*x = StatsList{}
+	mi := &file_cachepb_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsList) ProtoMessage() {}
+
+func (x *StatsList) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsList.ProtoReflect.Descriptor instead.
+func (*StatsList) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *StatsList) GetStats() []*GroupStats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type KeyValue struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Version       uint64                 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KeyValue) Reset() {
+	*x = KeyValue{}
+	mi := &file_cachepb_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyValue) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyValue) ProtoMessage() {}
+
+func (x *KeyValue) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyValue.ProtoReflect.Descriptor instead.
+func (*KeyValue) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *KeyValue) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *KeyValue) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *KeyValue) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type MultiGetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Keys          []string               `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiGetRequest) Reset() {
+	*x = MultiGetRequest{}
+	mi := &file_cachepb_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiGetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiGetRequest) ProtoMessage() {}
+
+func (x *MultiGetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiGetRequest.ProtoReflect.Descriptor instead.
+func (*MultiGetRequest) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *MultiGetRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *MultiGetRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type MultiGetResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entries       []*KeyValue            `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiGetResponse) Reset() {
+	*x = MultiGetResponse{}
+	mi := &file_cachepb_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiGetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiGetResponse) ProtoMessage() {}
+
+func (x *MultiGetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiGetResponse.ProtoReflect.Descriptor instead.
+func (*MultiGetResponse) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *MultiGetResponse) GetEntries() []*KeyValue {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+type MultiSetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Entries       []*KeyValue            `protobuf:"bytes,2,rep,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiSetRequest) Reset() {
+	*x = MultiSetRequest{}
+	mi := &file_cachepb_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiSetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiSetRequest) ProtoMessage() {}
+
+func (x *MultiSetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiSetRequest.ProtoReflect.Descriptor instead.
+func (*MultiSetRequest) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MultiSetRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *MultiSetRequest) GetEntries() []*KeyValue {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+type MultiDeleteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Keys          []string               `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiDeleteRequest) Reset() {
+	*x = MultiDeleteRequest{}
+	mi := &file_cachepb_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiDeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiDeleteRequest) ProtoMessage() {}
+
+func (x *MultiDeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiDeleteRequest.ProtoReflect.Descriptor instead.
+func (*MultiDeleteRequest) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *MultiDeleteRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *MultiDeleteRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type MultiDeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deleted       []string               `protobuf:"bytes,1,rep,name=deleted,proto3" json:"deleted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiDeleteResponse) Reset() {
+	*x = MultiDeleteResponse{}
+	mi := &file_cachepb_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiDeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiDeleteResponse) ProtoMessage() {}
+
+func (x *MultiDeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_cachepb_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
 
-var file_cachepb_proto_rawDesc = []byte{
-	0x0a, 0x0d, 0x63, 0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22,
-	0x34, 0x0a, 0x0a, 0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a,
-	0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72,
-	0x6f, 0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x4a, 0x0a, 0x0a, 0x53, 0x65, 0x74, 0x52, 0x65, 0x71, 0x75,
-	0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76,
-	0x61, 0x6c, 0x75, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
-	0x65, 0x22, 0x37, 0x0a, 0x0d, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x20, 0x0a, 0x08, 0x52, 0x65,
-	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x2a, 0x0a, 0x09,
-	0x47, 0x72, 0x6f, 0x75, 0x70, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x67, 0x72, 0x6f,
-	0x75, 0x70, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x67,
-	0x72, 0x6f, 0x75, 0x70, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0x20, 0x0a, 0x0c, 0x47, 0x72, 0x6f, 0x75,
-	0x70, 0x4b, 0x65, 0x79, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18,
-	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x42, 0x0a, 0x5a, 0x08, 0x2f, 0x63,
-	0x61, 0x63, 0x68, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+// Deprecated: Use MultiDeleteResponse.ProtoReflect.Descriptor instead.
+func (*MultiDeleteResponse) Descriptor() ([]byte, []int) {
+	return file_cachepb_proto_rawDescGZIP(), []int{15}
 }
 
+func (x *MultiDeleteResponse) GetDeleted() []string {
+	if x != nil {
+		return x.Deleted
+	}
+	return nil
+}
+
+var File_cachepb_proto protoreflect.FileDescriptor
+
+const file_cachepb_proto_rawDesc = "" +
+	"\n" +
+	"\rcachepb.proto\"d\n" +
+	"\n" +
+	"GetRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12.\n" +
+	"\vconsistency\x18\x03 \x01(\x0e2\f.ConsistencyR\vconsistency\"\x94\x01\n" +
+	"\n" +
+	"SetRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\fR\x05value\x12.\n" +
+	"\vconsistency\x18\x04 \x01(\x0e2\f.ConsistencyR\vconsistency\x12\x18\n" +
+	"\aversion\x18\x05 \x01(\x04R\aversion\"7\n" +
+	"\rDeleteRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\":\n" +
+	"\bResponse\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\fR\x05value\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x04R\aversion\"*\n" +
+	"\tGroupList\x12\x1d\n" +
+	"\n" +
+	"group_name\x18\x01 \x03(\tR\tgroupName\" \n" +
+	"\fGroupKeyList\x12\x10\n" +
+	"\x03key\x18\x01 \x03(\tR\x03key\"Q\n" +
+	"\fSnapshotInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x12\x19\n" +
+	"\bmod_time\x18\x03 \x01(\x03R\amodTime\";\n" +
+	"\fSnapshotList\x12+\n" +
+	"\tsnapshots\x18\x01 \x03(\v2\r.SnapshotInfoR\tsnapshots\"\xac\x05\n" +
+	"\n" +
+	"GroupStats\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x12\n" +
+	"\x04gets\x18\x02 \x01(\x03R\x04gets\x12\x1d\n" +
+	"\n" +
+	"cache_hits\x18\x03 \x01(\x03R\tcacheHits\x12\x14\n" +
+	"\x05loads\x18\x04 \x01(\x03R\x05loads\x12#\n" +
+	"\rloads_deduped\x18\x05 \x01(\x03R\floadsDeduped\x12\x1d\n" +
+	"\n" +
+	"peer_loads\x18\x06 \x01(\x03R\tpeerLoads\x12\x1f\n" +
+	"\vpeer_errors\x18\a \x01(\x03R\n" +
+	"peerErrors\x12\x1f\n" +
+	"\vlocal_loads\x18\b \x01(\x03R\n" +
+	"localLoads\x12&\n" +
+	"\x0flocal_load_errs\x18\t \x01(\x03R\rlocalLoadErrs\x12\x1c\n" +
+	"\tevictions\x18\n" +
+	" \x01(\x03R\tevictions\x12\x14\n" +
+	"\x05bytes\x18\v \x01(\x03R\x05bytes\x12\x14\n" +
+	"\x05items\x18\f \x01(\x03R\x05items\x12#\n" +
+	"\rnegative_hits\x18\r \x01(\x03R\fnegativeHits\x12\x1d\n" +
+	"\n" +
+	"stale_hits\x18\x0e \x01(\x03R\tstaleHits\x12\x1c\n" +
+	"\trefreshes\x18\x0f \x01(\x03R\trefreshes\x12\x16\n" +
+	"\x06writes\x18\x10 \x01(\x03R\x06writes\x12!\n" +
+	"\fwrite_errors\x18\x11 \x01(\x03R\vwriteErrors\x12%\n" +
+	"\x0ewrites_dropped\x18\x12 \x01(\x03R\rwritesDropped\x12%\n" +
+	"\x0epending_writes\x18\x13 \x01(\x03R\rpendingWrites\x12\x16\n" +
+	"\x06hedges\x18\x14 \x01(\x03R\x06hedges\x12!\n" +
+	"\fpeer_retries\x18\x15 \x01(\x03R\vpeerRetries\x12!\n" +
+	"\fread_repairs\x18\x16 \x01(\x03R\vreadRepairs\".\n" +
+	"\tStatsList\x12!\n" +
+	"\x05stats\x18\x01 \x03(\v2\v.GroupStatsR\x05stats\"L\n" +
+	"\bKeyValue\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x04R\aversion\";\n" +
+	"\x0fMultiGetRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x12\n" +
+	"\x04keys\x18\x02 \x03(\tR\x04keys\"7\n" +
+	"\x10MultiGetResponse\x12#\n" +
+	"\aentries\x18\x01 \x03(\v2\t.KeyValueR\aentries\"L\n" +
+	"\x0fMultiSetRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12#\n" +
+	"\aentries\x18\x02 \x03(\v2\t.KeyValueR\aentries\">\n" +
+	"\x12MultiDeleteRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x12\n" +
+	"\x04keys\x18\x02 \x03(\tR\x04keys\"/\n" +
+	"\x13MultiDeleteResponse\x12\x18\n" +
+	"\adeleted\x18\x01 \x03(\tR\adeleted*8\n" +
+	"\vConsistency\x12\v\n" +
+	"\aDEFAULT\x10\x00\x12\a\n" +
+	"\x03ONE\x10\x01\x12\n" +
+	"\n" +
+	"\x06QUORUM\x10\x02\x12\a\n" +
+	"\x03ALL\x10\x03B\n" +
+	"Z\b/cachepbb\x06proto3"
+
 var (
 	file_cachepb_proto_rawDescOnce sync.Once
-	file_cachepb_proto_rawDescData = file_cachepb_proto_rawDesc
+	file_cachepb_proto_rawDescData []byte
 )
 
 func file_cachepb_proto_rawDescGZIP() []byte {
 	file_cachepb_proto_rawDescOnce.Do(func() {
-		file_cachepb_proto_rawDescData = protoimpl.X.CompressGZIP(file_cachepb_proto_rawDescData)
+		file_cachepb_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_cachepb_proto_rawDesc), len(file_cachepb_proto_rawDesc)))
 	})
 	return file_cachepb_proto_rawDescData
 }
 
-var file_cachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
-var file_cachepb_proto_goTypes = []interface{}{
-	(*GetRequest)(nil),    // 0: GetRequest
-	(*SetRequest)(nil),    // 1: SetRequest
-	(*DeleteRequest)(nil), // 2: DeleteRequest
-	(*Response)(nil),      // 3: Response
-	(*GroupList)(nil),     // 4: GroupList
-	(*GroupKeyList)(nil),  // 5: GroupKeyList
+var file_cachepb_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_cachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_cachepb_proto_goTypes = []any{
+	(Consistency)(0),            // 0: Consistency
+	(*GetRequest)(nil),          // 1: GetRequest
+	(*SetRequest)(nil),          // 2: SetRequest
+	(*DeleteRequest)(nil),       // 3: DeleteRequest
+	(*Response)(nil),            // 4: Response
+	(*GroupList)(nil),           // 5: GroupList
+	(*GroupKeyList)(nil),        // 6: GroupKeyList
+	(*SnapshotInfo)(nil),        // 7: SnapshotInfo
+	(*SnapshotList)(nil),        // 8: SnapshotList
+	(*GroupStats)(nil),          // 9: GroupStats
+	(*StatsList)(nil),           // 10: StatsList
+	(*KeyValue)(nil),            // 11: KeyValue
+	(*MultiGetRequest)(nil),     // 12: MultiGetRequest
+	(*MultiGetResponse)(nil),    // 13: MultiGetResponse
+	(*MultiSetRequest)(nil),     // 14: MultiSetRequest
+	(*MultiDeleteRequest)(nil),  // 15: MultiDeleteRequest
+	(*MultiDeleteResponse)(nil), // 16: MultiDeleteResponse
 }
 var file_cachepb_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0,  // 0: GetRequest.consistency:type_name -> Consistency
+	0,  // 1: SetRequest.consistency:type_name -> Consistency
+	7,  // 2: SnapshotList.snapshots:type_name -> SnapshotInfo
+	9,  // 3: StatsList.stats:type_name -> GroupStats
+	11, // 4: MultiGetResponse.entries:type_name -> KeyValue
+	11, // 5: MultiSetRequest.entries:type_name -> KeyValue
+	6,  // [6:6] is the sub-list for method output_type
+	6,  // [6:6] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_cachepb_proto_init() }
@@ -394,96 +1208,22 @@ func file_cachepb_proto_init() {
 	if File_cachepb_proto != nil {
 		return
 	}
-	if !protoimpl.UnsafeEnabled {
-		file_cachepb_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GetRequest); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_cachepb_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*SetRequest); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_cachepb_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DeleteRequest); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_cachepb_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Response); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_cachepb_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GroupList); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_cachepb_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GroupKeyList); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
-			RawDescriptor: file_cachepb_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   6,
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_cachepb_proto_rawDesc), len(file_cachepb_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_cachepb_proto_goTypes,
 		DependencyIndexes: file_cachepb_proto_depIdxs,
+		EnumInfos:         file_cachepb_proto_enumTypes,
 		MessageInfos:      file_cachepb_proto_msgTypes,
 	}.Build()
 	File_cachepb_proto = out.File
-	file_cachepb_proto_rawDesc = nil
 	file_cachepb_proto_goTypes = nil
 	file_cachepb_proto_depIdxs = nil
 }
@@ -0,0 +1,321 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//集群范围的组目录，记录通过接口创建、修改容量与删除的组，每一项带有修改时目录的版本。
+//节点之间交换完整的目录，每个组保留版本更高的一项，因此重复或者乱序的消息不会产生影响，
+//被删除的组以墓碑的形式保留在目录中，避免被版本更低的目录或者快照重新创建。新节点加入时从其他节点拉取目录。
+//目录中的组配置优先于组文件与快照中的配置，组文件在目录变化之后根据当前的组重新生成
+
+// 目录文件的文件名，位于数据目录中
+const catalogFileName = "catalog.json"
+
+// 向其他节点发送或拉取目录的超时时间
+const catalogTimeout = 3 * time.Second
+
+// CatalogEntry 目录中的一个组
+type CatalogEntry struct {
+	Info    GroupInfo
+	Version uint64 // 该组最后一次被修改时目录的版本
+	Origin  string // 进行修改的节点，版本相同时用来决定保留哪一项
+	Deleted bool   // 组是否已经被删除
+}
+
+// 判断e是否比o更新
+func (e CatalogEntry) newer(o CatalogEntry) bool {
+	if e.Version != o.Version {
+		return e.Version > o.Version
+	}
+	return e.Origin > o.Origin
+}
+
+// Catalog 组目录，Version为目录中最大的版本
+type Catalog struct {
+	Version uint64
+	Index   uint64 // 开启raft时已经应用的最后一条日志的位置
+	Groups  map[string]CatalogEntry
+}
+
+var (
+	catalogMu sync.Mutex
+	catalog   = Catalog{Groups: make(map[string]CatalogEntry)}
+)
+
+// GetCatalog 获得当前目录的拷贝
+func GetCatalog() Catalog {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	return Catalog{Version: catalog.Version, Index: catalog.Index, Groups: maps.Clone(catalog.Groups)}
+}
+
+// 在本地创建、修改或删除一个组，目录的版本加一，返回修改之后的目录，用于发送给其他节点
+func catalogSet(origin string, info GroupInfo, deleted bool) Catalog {
+	catalogMu.Lock()
+	catalog.Version++
+	e := CatalogEntry{Info: info, Version: catalog.Version, Origin: origin, Deleted: deleted}
+	catalog.Groups[info.Name] = e
+	applyCatalogEntry(e)
+	saveCatalog()
+	catalogMu.Unlock()
+	UpdateGroupInfo()
+	return GetCatalog()
+}
+
+// MergeCatalog 合并其他节点的目录，每个组保留版本更高的一项，返回本地的目录是否发生了变化
+func MergeCatalog(remote Catalog) bool {
+	catalogMu.Lock()
+	changed := false
+	for name, e := range remote.Groups {
+		if local, ok := catalog.Groups[name]; ok && !e.newer(local) {
+			continue
+		}
+		catalog.Groups[name] = e
+		applyCatalogEntry(e)
+		changed = true
+	}
+	if remote.Version > catalog.Version {
+		catalog.Version = remote.Version
+		changed = true
+	}
+	if changed {
+		saveCatalog()
+	}
+	catalogMu.Unlock()
+	if changed {
+		UpdateGroupInfo()
+	}
+	return changed
+}
+
+// 应用通过raft提交的修改，版本为日志的位置，重启之后raft重新应用日志时跳过已经应用过的修改
+func applyCatalogAt(index uint64, e CatalogEntry) {
+	catalogMu.Lock()
+	if index <= catalog.Index {
+		catalogMu.Unlock()
+		return
+	}
+	e.Version = index
+	catalog.Groups[e.Info.Name] = e
+	catalog.Index = index
+	catalog.Version = max(catalog.Version, index)
+	applyCatalogEntry(e)
+	saveCatalog()
+	catalogMu.Unlock()
+	UpdateGroupInfo()
+}
+
+// 将目录中的一项应用到本地的组上，已经存在的组应用目录中的全部配置，在持有catalogMu的情况下调用
+func applyCatalogEntry(e CatalogEntry) {
+	g := GetGroup(e.Info.Name)
+	switch {
+	case e.Deleted:
+		if g != nil {
+			DeleteGroup(e.Info.Name)
+		}
+	case g == nil:
+		NewGroupWithInfo(e.Info, nil)
+	default:
+		g.reconfigure(e.Info)
+	}
+}
+
+// 使用info修改已经存在的组，只有容量不同时直接修改容量，其他配置不同时使用新的配置重新创建组，
+// 新的组保留原来的getter、内存中的数据与删除标记，开启了磁盘缓存时继续使用原来的磁盘数据
+func (g *Group) reconfigure(info GroupInfo) {
+	if info.Policy == "" {
+		info.Policy = PolicyLRU
+	}
+	info.Num = 0
+	cur := g.Info()
+	cur.CacheBytes = info.CacheBytes
+	if reflect.DeepEqual(cur, info) {
+		if g.mainCache.maxBytes() != info.CacheBytes {
+			g.mainCache.setCacheBytes(info.CacheBytes)
+			replicateGroup(g.Info(), false)
+		}
+		return
+	}
+	keys, values := g.mainCache.entries()
+	ng := NewGroupWithInfo(info, g.getter)
+	g.mainCache.copyTombstones(&ng.mainCache)
+	ng.mainCache.addList(keys, values)
+}
+
+// 将目录写入数据目录，在持有catalogMu的情况下调用
+func saveCatalog() {
+	data, err := json.Marshal(&catalog)
+	if err != nil {
+		log.Printf("[Zcache] failed to encode catalog: %v", err)
+		return
+	}
+	f, err := os.CreateTemp(dataDir, catalogFileName+".tmp*")
+	if err != nil {
+		log.Printf("[Zcache] failed to save catalog: %v", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dataPath(catalogFileName))
+	}
+	if err != nil {
+		log.Printf("[Zcache] failed to save catalog: %v", err)
+	}
+}
+
+// LoadCatalog 加载数据目录中的目录文件，并应用到本地的组上，应在LoadGroups之后调用
+func LoadCatalog() error {
+	data, err := os.ReadFile(dataPath(catalogFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c := Catalog{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("decode catalog: %w", err)
+	}
+	// 开启raft时目录记录了已经应用的日志位置，重启之后raft不会重复应用
+	catalogMu.Lock()
+	catalog.Index = max(catalog.Index, c.Index)
+	catalogMu.Unlock()
+	MergeCatalog(c)
+	return nil
+}
+
+// 组是否在目录中被删除，被删除的组不会从快照或者其他节点的数据中重新创建
+func catalogDeleted(name string) bool {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	return catalog.Groups[name].Deleted
+}
+
+//---------------------------------------------------------------------------------------------------------------------
+
+// 将目录发送给其他所有节点，发送失败的节点会在之后的同步中拉取到目录
+func (p *HTTPPool) broadcastCatalog(c Catalog) {
+	ctx, cancel := context.WithTimeout(context.Background(), catalogTimeout)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	for _, getter := range p.otherPeers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := getter.ApplyCatalog(ctx, c); err != nil {
+				p.Log("send catalog to %s failed: %v", getter.BaseURL, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// SyncCatalog 从其他所有节点拉取目录并合并到本地，节点加入集群时调用
+func (p *HTTPPool) SyncCatalog() {
+	ctx, cancel := context.WithTimeout(context.Background(), catalogTimeout)
+	defer cancel()
+	wg := sync.WaitGroup{}
+	for _, getter := range p.otherPeers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := Catalog{}
+			if err := getter.GetCatalog(ctx, &c); err != nil {
+				p.Log("fetch catalog from %s failed: %v", getter.BaseURL, err)
+				return
+			}
+			if MergeCatalog(c) {
+				p.Log("catalog updated to version %d from %s", c.Version, getter.BaseURL)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// StartCatalogSync 每隔interval从其他节点拉取一次目录，直到ctx被取消，用于修复广播失败的节点
+func (p *HTTPPool) StartCatalogSync(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.SyncCatalog()
+			}
+		}
+	}()
+}
+
+// 获得除自己之外的所有节点
+func (p *HTTPPool) otherPeers() []*HttpGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]*HttpGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			res = append(res, getter)
+		}
+	}
+	return res
+}
+
+// GetCatalog 获得远程节点的组目录
+func (h *HttpGetter) GetCatalog(ctx context.Context, out *Catalog) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.BaseURL+"/GetCatalog", nil)
+	if err != nil {
+		return err
+	}
+	return h.doJSON(req, out)
+}
+
+// ApplyCatalog 将目录发送给远程节点合并
+func (h *HttpGetter) ApplyCatalog(ctx context.Context, c Catalog) error {
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.BaseURL+"/ApplyCatalog", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return h.doJSON(req, nil)
+}
+
+// 发送请求并将JSON格式的响应解码到out中，out为nil时忽略响应
+func (h *HttpGetter) doJSON(req *http.Request, out any) error {
+	res, err := h.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
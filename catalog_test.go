@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestMergeCatalog(t *testing.T) {
+	if err := SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDataDir(".")
+	name := "catalog-test"
+	defer DeleteGroup(name)
+	entry := func(version uint64, cacheBytes int64, deleted bool) Catalog {
+		e := CatalogEntry{Info: GroupInfo{Name: name, CacheBytes: cacheBytes}, Version: version, Origin: "peer", Deleted: deleted}
+		return Catalog{Version: version, Groups: map[string]CatalogEntry{name: e}}
+	}
+	base := GetCatalog().Version
+
+	if !MergeCatalog(entry(base+1, 4096, false)) {
+		t.Fatal("newer catalog not merged")
+	}
+	g := GetGroup(name)
+	if g == nil || g.Info().CacheBytes != 4096 {
+		t.Fatalf("group not created, got %v", g)
+	}
+	g.Set("k", ByteView{b: []byte("value")})
+
+	// 修改容量时保留组中的数据
+	MergeCatalog(entry(base+2, 8192, false))
+	if GetGroup(name) != g || g.Info().CacheBytes != 8192 {
+		t.Fatalf("group not resized, got %+v", g.Info())
+	}
+	if _, ok := g.mainCache.get("k"); !ok {
+		t.Fatal("data lost after resize")
+	}
+
+	// 容量之外的配置同样被应用，组以新的配置重建并保留数据
+	c := entry(base+3, 8192, false)
+	e := c.Groups[name]
+	e.Info.TTL = 60
+	c.Groups[name] = e
+	if !MergeCatalog(c) {
+		t.Fatal("changed config not merged")
+	}
+	if g = GetGroup(name); g.Info().TTL != 60 {
+		t.Fatalf("ttl not applied, got %+v", g.Info())
+	}
+	if _, ok := g.mainCache.get("k"); !ok {
+		t.Fatal("data lost after reconfigure")
+	}
+
+	// 版本更低的目录不会产生影响
+	if MergeCatalog(entry(base+1, 1024, false)) {
+		t.Fatal("older catalog merged")
+	}
+	if g.Info().CacheBytes != 8192 {
+		t.Fatalf("older catalog applied, got %+v", g.Info())
+	}
+
+	MergeCatalog(entry(base+4, 0, true))
+	if GetGroup(name) != nil {
+		t.Fatal("group not deleted")
+	}
+	// 墓碑阻止版本更低的目录重新创建组
+	MergeCatalog(entry(base+2, 8192, false))
+	if GetGroup(name) != nil {
+		t.Fatal("deleted group recreated by older catalog")
+	}
+
+	// 本地的修改使用比已知版本更高的版本
+	c = catalogSet("self", GroupInfo{Name: name, CacheBytes: 2048}, false)
+	if c.Version != base+5 || GetGroup(name) == nil {
+		t.Fatalf("local create failed, catalog version %d", c.Version)
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"cache"
 	"cache/cachepb/cachepb"
+	"cache/raft"
 	"cache/service"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -83,12 +87,60 @@ func commandExplainer(input string) {
 	}
 	switch words[0] {
 	case "createGroup":
+		if inputLen != 3 {
+			showError(errors.New("unexpected command,use createGroup to see the usage"))
+			return
+		}
+		maxBytes, err := strconv.ParseInt(words[2], 10, 64)
+		if err != nil {
+			showError(err)
+			return
+		}
+		if err := client.CreateGroup(words[1], maxBytes); err != nil {
+			showError(err)
+			return
+		}
+		fmt.Println("success")
+	case "setPeers":
+		if inputLen < 2 {
+			showError(errors.New("unexpected command,use setPeers to see the usage"))
+			return
+		}
+		if err := client.SetPeers(words[1:]); err != nil {
+			showError(err)
+			return
+		}
+		fmt.Println("OK")
+	case "invalidate":
+		group, key := "default", ""
 		if inputLen == 3 {
-			client.CreateGroup(words[1])
-			fmt.Println("success")
+			group, key = words[1], words[2]
+		} else if inputLen == 2 {
+			key = words[1]
 		} else {
-			showError(errors.New("unexpected command,use createGroup to see the usage"))
+			showError(errors.New("unexpected command,use invalidate to see the usage"))
+			return
+		}
+		if err := client.Invalidate(group, key); err != nil {
+			showError(err)
+			return
 		}
+		fmt.Println("OK")
+	case "updateGroup":
+		if inputLen != 3 {
+			showError(errors.New("unexpected command,use updateGroup to see the usage"))
+			return
+		}
+		maxBytes, err := strconv.ParseInt(words[2], 10, 64)
+		if err != nil {
+			showError(err)
+			return
+		}
+		if err := client.UpdateGroup(words[1], maxBytes); err != nil {
+			showError(err)
+			return
+		}
+		fmt.Println("OK")
 	case "set":
 		in := cachepb.SetRequest{}
 		if inputLen == 4 {
@@ -122,7 +174,9 @@ func commandExplainer(input string) {
 			return
 		}
 		out := cachepb.Response{}
-		if err := client.Get(&in, &out); err != nil {
+		if err := client.Get(&in, &out); errors.Is(err, cache.ErrNotFound) {
+			fmt.Println("(nil)")
+		} else if err != nil {
 			showError(err)
 		} else {
 			fmt.Println(string(out.Value))
@@ -167,11 +221,146 @@ func commandExplainer(input string) {
 			return
 		}
 		fmt.Println("OK")
+	case "mget":
+		if inputLen < 3 {
+			showError(errors.New("unexpected command,use mget to see the usage"))
+			return
+		}
+		out := cachepb.MultiGetResponse{}
+		if err := client.GetMulti(&cachepb.MultiGetRequest{Group: words[1], Keys: words[2:]}, &out); err != nil {
+			showError(err)
+			return
+		}
+		found := make(map[string][]byte, len(out.Entries))
+		for _, e := range out.Entries {
+			found[e.Key] = e.Value
+		}
+		for _, key := range words[2:] {
+			if v, ok := found[key]; ok {
+				fmt.Printf("%s: %s\n", key, string(v))
+			} else {
+				fmt.Printf("%s: (nil)\n", key)
+			}
+		}
+	case "mset":
+		if inputLen < 4 || inputLen%2 != 0 {
+			showError(errors.New("unexpected command,use mset to see the usage"))
+			return
+		}
+		in := cachepb.MultiSetRequest{Group: words[1]}
+		for i := 2; i < inputLen; i += 2 {
+			in.Entries = append(in.Entries, &cachepb.KeyValue{Key: words[i], Value: []byte(words[i+1])})
+		}
+		out := cachepb.Response{}
+		if err := client.SetMulti(&in, &out); err != nil {
+			showError(err)
+		} else {
+			fmt.Println(string(out.Value))
+		}
+	case "mdelete":
+		if inputLen < 3 {
+			showError(errors.New("unexpected command,use mdelete to see the usage"))
+			return
+		}
+		out := cachepb.MultiDeleteResponse{}
+		if err := client.DeleteMulti(&cachepb.MultiDeleteRequest{Group: words[1], Keys: words[2:]}, &out); err != nil {
+			showError(err)
+			return
+		}
+		fmt.Printf("%d deleted\n", len(out.Deleted))
+	case "stats":
+		if inputLen != 2 {
+			showError(errors.New("unexpected command,use stats to see the usage"))
+			return
+		}
+		showStats(words[1])
+	case "save":
+		if inputLen != 2 {
+			showError(errors.New("unexpected command,use save to see the usage"))
+			return
+		}
+		saveSnapshot(words[1])
+	case "restore":
+		groupName := ""
+		if inputLen == 3 {
+			groupName = words[2]
+		} else if inputLen != 2 {
+			showError(errors.New("unexpected command,use restore to see the usage"))
+			return
+		}
+		if err := client.RestoreSnapshot(words[1], groupName); err != nil {
+			showError(err)
+			return
+		}
+		fmt.Println("OK")
+	case "export":
+		if inputLen != 3 {
+			showError(errors.New("unexpected command,use export to see the usage"))
+			return
+		}
+		f, err := os.Create(words[2])
+		if err != nil {
+			showError(err)
+			return
+		}
+		defer f.Close()
+		if err := client.ExportGroup(words[1], f); err != nil {
+			showError(err)
+			return
+		}
+		fmt.Println("OK")
+	case "import":
+		groupName := ""
+		if inputLen == 3 {
+			groupName = words[2]
+		} else if inputLen != 2 {
+			showError(errors.New("unexpected command,use import to see the usage"))
+			return
+		}
+		f, err := os.Open(words[1])
+		if err != nil {
+			showError(err)
+			return
+		}
+		defer f.Close()
+		if err := client.ImportGroup(groupName, f); err != nil {
+			showError(err)
+			return
+		}
+		fmt.Println("OK")
 	default:
 		showError(errors.New("unknown command"))
 	}
 }
 
+// 打印组的统计信息，groupName为空时打印所有组
+func showStats(groupName string) {
+	out := cachepb.StatsList{}
+	if err := client.GetStats(groupName, &out); err != nil {
+		showError(err)
+		return
+	}
+	for _, v := range out.Stats {
+		fmt.Printf("[%s]\n", v.Group)
+		fmt.Printf("  gets: %d  cache hits: %d  loads: %d  deduped loads: %d\n", v.Gets, v.CacheHits, v.Loads, v.LoadsDeduped)
+		fmt.Printf("  peer loads: %d  peer errors: %d  local loads: %d  load errors: %d\n", v.PeerLoads, v.PeerErrors, v.LocalLoads, v.LocalLoadErrs)
+		fmt.Printf("  hedges: %d  peer retries: %d  read repairs: %d\n", v.Hedges, v.PeerRetries, v.ReadRepairs)
+		fmt.Printf("  stale hits: %d  refreshes: %d  negative hits: %d\n", v.StaleHits, v.Refreshes, v.NegativeHits)
+		fmt.Printf("  store writes: %d  write errors: %d  dropped writes: %d  pending writes: %d\n", v.Writes, v.WriteErrors, v.WritesDropped, v.PendingWrites)
+		fmt.Printf("  evictions: %d  bytes: %d  items: %d\n", v.Evictions, v.Bytes, v.Items)
+	}
+}
+
+// 保存快照并打印快照信息
+func saveSnapshot(name string) {
+	out := cachepb.SnapshotInfo{}
+	if err := client.SaveSnapshot(name, &out); err != nil {
+		showError(err)
+		return
+	}
+	fmt.Printf("%s\t%d bytes\t%s\n", out.Name, out.Size, time.Unix(out.ModTime, 0).Format(time.DateTime))
+}
+
 // 解析一条指令
 func explainOneCommand(command string) bool {
 	switch command {
@@ -192,6 +381,93 @@ func explainOneCommand(command string) bool {
 		for _, v := range out.Key {
 			fmt.Println(v)
 		}
+	case "save":
+		saveSnapshot("persistence")
+	case "stats":
+		showStats("")
+	case "catalog":
+		out := cache.Catalog{}
+		if err := client.GetCatalog(&out); err != nil {
+			showError(err)
+			return true
+		}
+		names := make([]string, 0, len(out.Groups))
+		for name := range out.Groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Printf("version %d\n", out.Version)
+		for _, name := range names {
+			e := out.Groups[name]
+			if e.Deleted {
+				fmt.Printf("%s\t(deleted)\tv%d\n", name, e.Version)
+			} else {
+				fmt.Printf("%s\t%d bytes\tv%d\n", name, e.Info.CacheBytes, e.Version)
+			}
+		}
+	case "raft":
+		out := raft.Status{}
+		if err := client.RaftStatus(&out); err != nil {
+			showError(err)
+			return true
+		}
+		fmt.Printf("id: %s\nstate: %s\nterm: %d\nleader: %s\ncommit: %d\napplied: %d\nlast: %d\npeers: %s\n",
+			out.ID, out.State, out.Term, out.Leader, out.CommitIndex, out.LastApplied, out.LastIndex, strings.Join(out.Peers, " "))
+	case "repl":
+		out := cache.ReplStatus{}
+		if err := client.ReplStatus(&out); err != nil {
+			showError(err)
+			return true
+		}
+		fmt.Printf("role: %s\nid: %s\noffset: %d\n", out.Role, out.ID, out.Offset)
+		if out.Role == "replica" {
+			fmt.Printf("primary: %s\nlag: %d\n", out.Primary, out.Lag)
+		}
+		for _, v := range out.Replicas {
+			fmt.Printf("replica %s\toffset %d\tlag %d\t%s\n", v.Addr, v.Offset, v.Lag, v.LastSeen.Format(time.DateTime))
+		}
+	case "promote":
+		if err := client.Promote(); err != nil {
+			showError(err)
+			return true
+		}
+		fmt.Println("OK")
+	case "antiEntropy", "antiEntropyStatus":
+		out := cache.AntiEntropyStatus{}
+		var err error
+		if command == "antiEntropy" {
+			err = client.AntiEntropy(&out)
+		} else {
+			err = client.AntiEntropyStatus(&out)
+		}
+		if err != nil {
+			showError(err)
+			return true
+		}
+		fmt.Printf("running: %v\nrounds: %d\nlast duration: %v\ntrees compared: %d\nranges differed: %d\nkeys repaired: %d\ntotal repaired: %d\n",
+			out.Running, out.Rounds, out.LastDuration, out.TreesCompared, out.RangesDiffered, out.KeysRepaired, out.TotalRepaired)
+		if out.LastError != "" {
+			fmt.Printf("last error: %s\n", out.LastError)
+		}
+	case "hints":
+		out := cache.HintStatus{}
+		if err := client.Hints(&out); err != nil {
+			showError(err)
+			return true
+		}
+		fmt.Printf("bytes: %d\nreplayed: %d\ndropped: %d\n", out.Bytes, out.Replayed, out.Dropped)
+		for peer, n := range out.Pending {
+			fmt.Printf("%s\t%d pending\n", peer, n)
+		}
+	case "snapshots":
+		out := cachepb.SnapshotList{}
+		if err := client.ListSnapshots(&out); err != nil {
+			showError(err)
+			return true
+		}
+		for _, v := range out.Snapshots {
+			fmt.Printf("%s\t%d bytes\t%s\n", v.Name, v.Size, time.Unix(v.ModTime, 0).Format(time.DateTime))
+		}
 	default:
 		return false
 	}
@@ -204,12 +480,39 @@ func explainUsage(word string) bool {
 	case "createGroup":
 		fmt.Println("createGroup -GroupName -MaxBytes")
 		return true
+	case "updateGroup":
+		fmt.Println("updateGroup -GroupName -MaxBytes")
+		return true
+	case "setPeers":
+		fmt.Println("setPeers -Peer1 -Peer2 ...")
+		return true
 	case "set":
 		fmt.Println("set -GroupName(default='default') -Key -Value")
 		return true
 	case "get":
 		fmt.Println("get -GroupName(default='default') -Key")
 		return true
+	case "mget":
+		fmt.Println("mget -GroupName -Key1 -Key2 ...")
+		return true
+	case "mset":
+		fmt.Println("mset -GroupName -Key1 -Value1 -Key2 -Value2 ...")
+		return true
+	case "mdelete":
+		fmt.Println("mdelete -GroupName -Key1 -Key2 ...")
+		return true
+	case "invalidate":
+		fmt.Println("invalidate -GroupName(default='default') -Key")
+		return true
+	case "restore":
+		fmt.Println("restore -SnapshotName -GroupName(default: all groups)")
+		return true
+	case "export":
+		fmt.Println("export -GroupName -FilePath")
+		return true
+	case "import":
+		fmt.Println("import -FilePath -GroupName(default: group in file)")
+		return true
 	default:
 		return false
 	}
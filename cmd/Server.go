@@ -2,35 +2,42 @@ package main
 
 import (
 	"cache/service"
+	"flag"
 	"fmt"
 	"github.com/spf13/viper"
 	"strconv"
 )
 
+var configPath = flag.String("c", "config.yml", "path of the config file")
+
 func main() {
+	flag.Parse()
 	//首先加载配置文件
-	LoadConfig()
-	s := service.NewServer(
-		viper.GetString("ip"),
-		viper.GetInt("port"),
-		viper.GetBool("persistence"),
-		viper.GetInt("persistence-time"),
-	)
+	c := LoadConfig(*configPath)
+	s := service.NewServer(c)
 	fmt.Println("========================================")
 	fmt.Println("  ______   _____           _          \n |___  /  / ____|         | |         \n    / /  | |     __ _  ___| |__   ___ \n   / /   | |    / _` |/ __| '_ \\ / _ \\\n  / /__  | |___| (_| | (__| | | |  __/\n /_____|  \\_____\\__,_|\\___|_| |_|\\___|")
 	fmt.Println("========================================")
 	fmt.Println("version : v0.2 beta")
-	fmt.Println("server listen at ", viper.GetString("ip"), ":", strconv.Itoa(viper.GetInt("port")))
-	fmt.Println("persistence : ", viper.GetBool("persistence"))
+	fmt.Println("server listen at ", c.IP, ":", strconv.Itoa(c.Port))
+	fmt.Println("persistence : ", c.Persistence)
+	fmt.Println("data dir : ", c.DataDir)
 	s.Run()
 }
 
-func LoadConfig() {
-	viper.SetConfigName("config.yml")
+// LoadConfig 加载配置文件，未配置的项使用默认值
+func LoadConfig(path string) service.Config {
+	viper.SetConfigFile(path)
 	viper.SetConfigType("yaml")
-	viper.AddConfigPath("./")
+	viper.SetDefault("persistence-time", 10)
+	viper.SetDefault("data-dir", ".")
 	err := viper.ReadInConfig()
 	if err != nil {
 		panic(err)
 	}
+	c := service.Config{}
+	if err := viper.Unmarshal(&c); err != nil {
+		panic(err)
+	}
+	return c
 }
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//多副本读写，组的replicas大于1时每个键保存在哈希环上从负责节点开始的replicas个节点上，
+//写入时由收到请求的节点生成版本并同时发给所有副本，按照一致性级别等待足够数量的副本确认，
+//读取时按照一致性级别等待足够数量的副本返回，使用其中版本最新的数据，并在后台修复返回了旧数据的副本(读修复)
+
+// ErrConsistency 确认的副本数量达不到一致性级别的要求
+var ErrConsistency = errors.New("not enough replicas")
+
+// 读修复时写入副本的超时时间
+const readRepairTimeout = time.Second
+
+var lastVersion atomic.Uint64
+
+// 记录从其他节点收到的版本，之后本地生成的版本总是比它新，因此本地的删除与写入不会被时钟较快的节点的旧数据覆盖
+func observeVersion(v uint64) {
+	for {
+		last := lastVersion.Load()
+		if v <= last || lastVersion.CompareAndSwap(last, v) {
+			return
+		}
+	}
+}
+
+// 生成写入的版本，使用纳秒时间戳，同一个节点上保证单调递增，不同节点之间以最后写入的为准
+func newVersion() uint64 {
+	for {
+		last := lastVersion.Load()
+		v := max(uint64(time.Now().UnixNano()), last+1)
+		if lastVersion.CompareAndSwap(last, v) {
+			return v
+		}
+	}
+}
+
+// 一致性级别要求的副本数量，n为副本总数，不会超过n，没有可用的副本时不要求确认
+func required(level cachepb.Consistency, n int) int {
+	switch level {
+	case cachepb.Consistency_QUORUM:
+		return n/2 + 1
+	case cachepb.Consistency_ALL:
+		return n
+	}
+	return min(1, n)
+}
+
+// 选择保存key的副本，没有设置节点或者节点不支持时只有自己
+func (g *Group) pickReplicas(key string) ([]PeerGetter, bool) {
+	if rp, ok := g.peers.(ReplicaPicker); ok {
+		return rp.PickReplicas(key, g.replicas)
+	}
+	return nil, true
+}
+
+// 副本返回的结果，peer为nil表示自己
+type replicaResult struct {
+	peer  PeerGetter
+	value ByteView
+	err   error
+}
+
+// GetConsistency 按照一致性级别读取数据，DEFAULT级别或者组只有一个副本时与GetContext相同，
+// 不存在的副本视为版本最旧，所有返回的副本都不存在时返回ErrNotFound
+func (g *Group) GetConsistency(ctx context.Context, key string, level cachepb.Consistency) (ByteView, error) {
+	if level == cachepb.Consistency_DEFAULT || g.replicas <= 1 {
+		return g.GetContext(ctx, key)
+	}
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	peers, self := g.pickReplicas(key)
+	n := len(peers)
+	if self {
+		n++
+	}
+	need := required(level, n)
+	// 返回时取消还未完成的请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan replicaResult, n)
+	if self {
+		go func() {
+			v, err := g.get(ctx, key, false)
+			ch <- replicaResult{value: v, err: err}
+		}()
+	}
+	for _, peer := range peers {
+		go func() {
+			v, err := g.requestPeer(ctx, peer, key)
+			ch <- replicaResult{peer: peer, value: v, err: err}
+		}()
+	}
+	var results []replicaResult
+	var lastErr error
+	for i := 0; i < n && len(results) < need; i++ {
+		select {
+		case r := <-ch:
+			if r.err != nil && !errors.Is(r.err, ErrNotFound) {
+				lastErr = r.err
+				continue
+			}
+			results = append(results, r)
+		case <-ctx.Done():
+			return ByteView{}, ctx.Err()
+		}
+	}
+	if len(results) < need {
+		return ByteView{}, fmt.Errorf("%w: %d of %d replicas responded, last error: %v", ErrConsistency, len(results), need, lastErr)
+	}
+	latest, found := replicaResult{}, false
+	for _, r := range results {
+		if r.err == nil && (!found || r.value.v > latest.value.v) {
+			latest, found = r, true
+		}
+	}
+	if !found {
+		return ByteView{}, ErrNotFound
+	}
+	g.readRepair(key, latest.value, results)
+	return latest.value, nil
+}
+
+// 将最新的数据写入返回了旧数据或者不存在的副本，没有版本的数据(从getter加载)不进行修复
+func (g *Group) readRepair(key string, latest ByteView, results []replicaResult) {
+	if latest.v == 0 {
+		return
+	}
+	for _, r := range results {
+		if r.err == nil && r.value.v >= latest.v {
+			continue
+		}
+		g.Stats.ReadRepairs.Add(1)
+		if r.peer == nil {
+			g.setVersioned(key, latest)
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), readRepairTimeout)
+			defer cancel()
+			if err := g.setReplica(ctx, r.peer, key, latest); err != nil {
+				log.Println("[Zcache] Failed to repair replica", err)
+			}
+		}()
+	}
+}
+
+// SetConsistency 按照一致性级别写入数据，组只有一个副本时与Set相同，
+// 数据源只写入一次，之后同时写入所有副本，达到一致性级别要求的数量后返回，其余副本在后台继续写入
+func (g *Group) SetConsistency(ctx context.Context, key string, value ByteView, level cachepb.Consistency) error {
+	if g.replicas <= 1 {
+		return g.Set(key, value)
+	}
+	if err := g.storeSet(key, value.b); err != nil {
+		return err
+	}
+	value.v = newVersion()
+	peers, self := g.pickReplicas(key)
+	n := len(peers)
+	acked := 0
+	if self {
+		n++
+		g.setVersioned(key, value)
+		acked++
+	}
+	need := required(level, n)
+	// 返回之后其余副本继续写入，直到请求的截止时间
+	ctx, cancel := detachContext(ctx)
+	ch := make(chan error, len(peers))
+	wg := sync.WaitGroup{}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := g.setReplica(ctx, peer, key, value)
+			// 保存了提示的副本不计入确认的数量
+			if err != nil {
+				_ = g.handoff(peer, key, value, err)
+			}
+			ch <- err
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+	var lastErr error
+	for range peers {
+		if acked >= need {
+			return nil
+		}
+		if err := <-ch; err != nil {
+			lastErr = err
+			continue
+		}
+		acked++
+	}
+	if acked < need {
+		return fmt.Errorf("%w: %d of %d replicas acknowledged, last error: %v", ErrConsistency, acked, need, lastErr)
+	}
+	return nil
+}
+
+// 向副本写入带版本的数据，副本不支持时返回错误
+func (g *Group) setReplica(ctx context.Context, peer PeerGetter, key string, value ByteView) error {
+	vs, ok := peer.(VersionedSetter)
+	if !ok {
+		return fmt.Errorf("peer %s does not support versioned set", peerAddr(peer))
+	}
+	req := &cachepb.SetRequest{Group: g.name, Key: key, Value: value.b, Version: value.v}
+	return vs.SetVersioned(ctx, req, &cachepb.Response{})
+}
+
+// 只在版本比已有数据新时写入本地缓存，不写入数据源，返回是否写入
+func (g *Group) setVersioned(key string, value ByteView) bool {
+	g.loader.Forget(key)
+	value = g.withTTL(value)
+	if !g.mainCache.addIfNewer(key, value) {
+		return false
+	}
+	g.diskDelete(key)
+	g.negativeDelete(key)
+	g.replicate(replSet, key, value)
+	return true
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 在内存中保存带版本数据的副本
+type fakeReplica struct {
+	mu   sync.Mutex
+	data map[string]ByteView
+	down bool
+}
+
+func (r *fakeReplica) Get(in *cachepb.GetRequest, out *cachepb.Response) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errors.New("connection refused")
+	}
+	v, ok := r.data[in.Key]
+	if !ok {
+		return ErrNotFound
+	}
+	out.Value, out.Version = v.b, v.v
+	return nil
+}
+
+func (r *fakeReplica) SetVersioned(_ context.Context, in *cachepb.SetRequest, _ *cachepb.Response) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errors.New("connection refused")
+	}
+	if v, ok := r.data[in.Key]; !ok || v.v < in.Version {
+		r.data[in.Key] = ByteView{b: in.Value, v: in.Version}
+	}
+	return nil
+}
+
+func (r *fakeReplica) get(key string) ByteView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.data[key]
+}
+
+type replicaPicker []PeerGetter
+
+func (p replicaPicker) PickPeer(string) (PeerGetter, bool) { return nil, false }
+
+func (p replicaPicker) PickReplicas(string, int) ([]PeerGetter, bool) { return p, true }
+
+func TestConsistency(t *testing.T) {
+	a := &fakeReplica{data: map[string]ByteView{}}
+	b := &fakeReplica{data: map[string]ByteView{}}
+	g := NewGroupWithInfo(GroupInfo{Name: "consistency-test", CacheBytes: 2048, Replicas: 3}, nil)
+	defer DeleteGroup(g.name)
+	g.peers = replicaPicker{a, b}
+	ctx := context.Background()
+
+	if err := g.SetConsistency(ctx, "k", ByteView{b: []byte("v1")}, cachepb.Consistency_ALL); err != nil {
+		t.Fatal(err)
+	}
+	if a.get("k").String() != "v1" || b.get("k").String() != "v1" {
+		t.Fatal("expect all replicas to be written")
+	}
+
+	// 一个副本有更新的版本，QUORUM读取到最新的数据并修复其他副本
+	b.SetVersioned(ctx, &cachepb.SetRequest{Key: "k", Value: []byte("v2"), Version: newVersion()}, nil)
+	if v, err := g.GetConsistency(ctx, "k", cachepb.Consistency_ALL); err != nil || v.String() != "v2" {
+		t.Fatalf("expect newest value, got %v %v", v, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for a.get("k").String() != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatal("stale replica not repaired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, _ := g.mainCache.get("k"); v.String() != "v2" {
+		t.Fatal("local replica not repaired")
+	}
+	if s := g.GetStats(); s.ReadRepairs != 2 {
+		t.Fatalf("expect 2 read repairs, got %d", s.ReadRepairs)
+	}
+
+	// 一个副本不可用时QUORUM仍然成功，ALL失败
+	b.mu.Lock()
+	b.down = true
+	b.mu.Unlock()
+	if err := g.SetConsistency(ctx, "k", ByteView{b: []byte("v3")}, cachepb.Consistency_QUORUM); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.GetConsistency(ctx, "k", cachepb.Consistency_QUORUM); err != nil || v.String() != "v3" {
+		t.Fatalf("expect quorum read to succeed, got %v %v", v, err)
+	}
+	if err := g.SetConsistency(ctx, "k", ByteView{b: []byte("v4")}, cachepb.Consistency_ALL); !errors.Is(err, ErrConsistency) {
+		t.Fatalf("expect ErrConsistency, got %v", err)
+	}
+}
+
+// 没有任何可用副本的节点选择器
+type emptyPicker struct{}
+
+func (emptyPicker) PickPeer(string) (PeerGetter, bool) { return nil, false }
+
+func (emptyPicker) PickReplicas(string, int) ([]PeerGetter, bool) { return nil, false }
+
+func TestConsistencyLevels(t *testing.T) {
+	name := "consistency-level-test"
+	g := NewGroupWithInfo(GroupInfo{Name: name, CacheBytes: 2048, Replicas: 3}, nil)
+	defer DeleteGroup(name)
+	// 要求的确认数量不超过副本的数量
+	g.peers = emptyPicker{}
+	if err := g.SetConsistency(context.Background(), "k", ByteView{b: []byte("v")}, cachepb.Consistency_ONE); err != nil {
+		t.Fatalf("expect write without replicas to succeed, got %v", err)
+	}
+	// 非法的一致性级别返回400
+	p := NewHTTPPool("http://self")
+	for _, level := range []string{"x", "9", "-1"} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?group="+name+"&key=k&consistency="+level, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expect 400 for consistency %s, got %d", level, w.Code)
+		}
+	}
+}
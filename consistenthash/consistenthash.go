@@ -50,3 +50,48 @@ func (m *Map) Get(key string) string {
 	})
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// GetN 从key在哈希环上的位置开始，按顺序获得最多n个不同的真实节点，第一个节点与Get的结果相同
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	seen := make(map[string]bool)
+	res := make([]string, 0, n)
+	for i := 0; i < len(m.keys) && len(res) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// Successors 获得哈希环上紧跟在真实节点key的各个虚拟节点之后的其他真实节点，
+// 当节点key不在环上时，它负责的数据会落在这些节点上
+func (m *Map) Successors(key string) []string {
+	seen := make(map[string]bool)
+	res := make([]string, 0)
+	for i, hash := range m.keys {
+		if m.hashMap[hash] != key {
+			continue
+		}
+		for j := 1; j < len(m.keys); j++ {
+			next := m.hashMap[m.keys[(i+j)%len(m.keys)]]
+			if next == key {
+				continue
+			}
+			if !seen[next] {
+				seen[next] = true
+				res = append(res, next)
+			}
+			break
+		}
+	}
+	return res
+}
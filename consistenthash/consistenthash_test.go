@@ -0,0 +1,61 @@
+package consistenthash
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestHashing(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点为 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+}
+
+func TestSuccessors(t *testing.T) {
+	hash := New(2, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点为 1, 3, 5, 11, 13, 15
+	hash.Add("1", "3", "5")
+	got := hash.Successors("3")
+	sort.Strings(got)
+	if expect := []string{"5"}; !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expect successors %v, got %v", expect, got)
+	}
+	got = hash.Successors("5")
+	if expect := []string{"1"}; !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expect successors %v, got %v", expect, got)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(2, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点为 1, 3, 5, 11, 13, 15
+	hash.Add("1", "3", "5")
+	if got, expect := hash.GetN("4", 2), []string{"5", "1"}; !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+	if got, expect := hash.GetN("14", 5), []string{"5", "1", "3"}; !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+}
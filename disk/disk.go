@@ -0,0 +1,361 @@
+// Package disk 本地磁盘缓存，用于保存从内存中淘汰的数据
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+数据以追加的方式写入段文件中，当活跃段超过segBytes时创建新的段，
+当所有段的大小超过maxBytes时删除最旧的段以及其中的数据。
+每条记录的格式为：crc32(4) | flag(1) | expire(8) | keyLen(4) | valueLen(4) | key | value，
+crc32校验的是crc之后的所有内容，flag为1时表示该记录为删除标记
+*/
+
+const (
+	headerLen = 4 + 1 + 8 + 4 + 4
+	segExt    = ".seg"
+
+	flagValue     byte = 0
+	flagTombstone byte = 1
+
+	// DefaultSegmentBytes 未指定段大小时使用的默认值
+	DefaultSegmentBytes int64 = 64 << 20
+)
+
+var errCorrupted = errors.New("disk: corrupted record")
+
+type segment struct {
+	id   int
+	f    *os.File
+	size int64
+}
+
+// 记录在段文件中的位置
+type location struct {
+	seg    int   // 段的id
+	off    int64 // 记录在段中的偏移量
+	size   int64 // 记录的总长度
+	expire int64 // 过期时间的unix纳秒时间戳，0表示永不过期
+}
+
+type Store struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64 //所有段允许使用的最大磁盘空间
+	segBytes int64 //单个段的最大大小
+	nbytes   int64 //当前所有段的大小
+	segments []*segment
+	index    map[string]location
+}
+
+// Open 打开dir中的磁盘缓存，目录不存在时会自动创建，已有的段文件会被扫描用来重建索引
+func Open(dir string, maxBytes int64, segBytes int64) (*Store, error) {
+	if segBytes <= 0 {
+		segBytes = DefaultSegmentBytes
+	}
+	if maxBytes > 0 && segBytes > maxBytes {
+		segBytes = maxBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:      dir,
+		maxBytes: maxBytes,
+		segBytes: segBytes,
+		index:    make(map[string]location),
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segExt))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(paths))
+	for _, p := range paths {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(p), segExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		seg, err := s.openSegment(id)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if err := s.scan(seg); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if len(s.segments) == 0 {
+		if _, err := s.openSegment(0); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) segPath(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d%s", id, segExt))
+}
+
+func (s *Store) openSegment(id int) (*segment, error) {
+	f, err := os.OpenFile(s.segPath(id), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	seg := &segment{id: id, f: f, size: stat.Size()}
+	s.segments = append(s.segments, seg)
+	s.nbytes += seg.size
+	return seg, nil
+}
+
+// 扫描段文件重建索引，遇到不完整或损坏的记录时截断该段之后的内容
+func (s *Store) scan(seg *segment) error {
+	r := bufio.NewReader(io.NewSectionReader(seg.f, 0, seg.size))
+	var off int64
+	for off < seg.size {
+		key, _, flag, expire, n, err := readRecord(r)
+		if err != nil {
+			s.nbytes -= seg.size - off
+			seg.size = off
+			return seg.f.Truncate(off)
+		}
+		if flag == flagTombstone {
+			delete(s.index, key)
+		} else {
+			s.index[key] = location{seg: seg.id, off: off, size: n, expire: expire}
+		}
+		off += n
+	}
+	return nil
+}
+
+func readRecord(r io.Reader) (key string, value []byte, flag byte, expire int64, n int64, err error) {
+	header := make([]byte, headerLen)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	flag = header[4]
+	expire = int64(binary.LittleEndian.Uint64(header[5:13]))
+	keyLen := binary.LittleEndian.Uint32(header[13:17])
+	valueLen := binary.LittleEndian.Uint32(header[17:21])
+	body := make([]byte, int(keyLen)+int(valueLen))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.LittleEndian.Uint32(header[:4]) {
+		err = errCorrupted
+		return
+	}
+	key = string(body[:keyLen])
+	value = body[keyLen:]
+	n = int64(headerLen + len(body))
+	return
+}
+
+func encodeRecord(key string, value []byte, flag byte, expire int64) []byte {
+	b := make([]byte, headerLen+len(key)+len(value))
+	b[4] = flag
+	binary.LittleEndian.PutUint64(b[5:13], uint64(expire))
+	binary.LittleEndian.PutUint32(b[13:17], uint32(len(key)))
+	binary.LittleEndian.PutUint32(b[17:21], uint32(len(value)))
+	copy(b[headerLen:], key)
+	copy(b[headerLen+len(key):], value)
+	binary.LittleEndian.PutUint32(b[:4], crc32.ChecksumIEEE(b[4:]))
+	return b
+}
+
+// 将记录追加到活跃段中，返回记录所在的位置
+func (s *Store) append(rec []byte) (location, error) {
+	active := s.segments[len(s.segments)-1]
+	if active.size > 0 && active.size+int64(len(rec)) > s.segBytes {
+		seg, err := s.openSegment(active.id + 1)
+		if err != nil {
+			return location{}, err
+		}
+		active = seg
+	}
+	if _, err := active.f.WriteAt(rec, active.size); err != nil {
+		return location{}, err
+	}
+	loc := location{seg: active.id, off: active.size, size: int64(len(rec))}
+	active.size += loc.size
+	s.nbytes += loc.size
+	return loc, nil
+}
+
+// 当所有段的大小超过maxBytes时，删除最旧的段以及索引中指向该段的数据
+func (s *Store) removeOldest() {
+	for s.maxBytes > 0 && s.nbytes > s.maxBytes && len(s.segments) > 1 {
+		oldest := s.segments[0]
+		s.segments = s.segments[1:]
+		for k, loc := range s.index {
+			if loc.seg == oldest.id {
+				delete(s.index, k)
+			}
+		}
+		s.nbytes -= oldest.size
+		oldest.f.Close()
+		os.Remove(s.segPath(oldest.id))
+	}
+}
+
+// Put 写入一个键值对，expire为过期时间的unix纳秒时间戳，0表示永不过期
+func (s *Store) Put(key string, value []byte, expire int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.segments == nil {
+		return os.ErrClosed
+	}
+	loc, err := s.append(encodeRecord(key, value, flagValue, expire))
+	if err != nil {
+		return err
+	}
+	loc.expire = expire
+	s.index[key] = loc
+	s.removeOldest()
+	return nil
+}
+
+// Get 读取一个键对应的值以及过期时间，已经过期的数据会被删除
+func (s *Store) Get(key string) (value []byte, expire int64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, 0, false
+	}
+	if loc.expire != 0 && time.Now().UnixNano() > loc.expire {
+		s.delete(key)
+		return nil, 0, false
+	}
+	seg := s.segment(loc.seg)
+	if seg == nil {
+		delete(s.index, key)
+		return nil, 0, false
+	}
+	_, value, _, expire, _, err := readRecord(io.NewSectionReader(seg.f, loc.off, loc.size))
+	if err != nil {
+		delete(s.index, key)
+		return nil, 0, false
+	}
+	return value, expire, true
+}
+
+// Has 判断磁盘中是否存在对应的键
+func (s *Store) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.index[key]
+	return ok
+}
+
+func (s *Store) segment(id int) *segment {
+	for _, seg := range s.segments {
+		if seg.id == id {
+			return seg
+		}
+	}
+	return nil
+}
+
+// Delete 删除一个键，通过写入删除标记保证重启后数据不会重新出现
+func (s *Store) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delete(key)
+}
+
+func (s *Store) delete(key string) bool {
+	if _, ok := s.index[key]; !ok {
+		return false
+	}
+	delete(s.index, key)
+	if _, err := s.append(encodeRecord(key, nil, flagTombstone, 0)); err != nil {
+		return true
+	}
+	s.removeOldest()
+	return true
+}
+
+// Keys 获得磁盘中所有键的列表
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]string, 0, len(s.index))
+	for k := range s.index {
+		res = append(res, k)
+	}
+	return res
+}
+
+// Len 磁盘中键的数量
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.index)
+}
+
+// Bytes 所有段文件占用的磁盘空间
+func (s *Store) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nbytes
+}
+
+// Clear 删除所有数据
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.segments == nil {
+		return os.ErrClosed
+	}
+	last := s.segments[len(s.segments)-1].id
+	for _, seg := range s.segments {
+		seg.f.Close()
+		os.Remove(s.segPath(seg.id))
+	}
+	s.segments = nil
+	s.nbytes = 0
+	s.index = make(map[string]location)
+	_, err := s.openSegment(last + 1)
+	return err
+}
+
+// Close 关闭所有段文件
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, seg := range s.segments {
+		if e := seg.f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.segments = nil
+	return err
+}
@@ -0,0 +1,80 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutGet(t *testing.T) {
+	s, err := Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Put("key1", []byte("1234"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, ok := s.Get("key1"); !ok || string(v) != "1234" {
+		t.Fatalf("disk hit key1=1234 failed")
+	}
+	if _, _, ok := s.Get("key2"); ok {
+		t.Fatalf("disk miss key2 failed")
+	}
+	if err := s.Put("expired", []byte("v"), time.Now().Add(-time.Second).UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.Get("expired"); ok {
+		t.Fatalf("expired key should not be returned")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("k1", []byte("v1"), 0)
+	s.Put("k2", []byte("v2"), 0)
+	s.Put("k1", []byte("v3"), 0)
+	s.Delete("k2")
+	s.Close()
+
+	// 在最后一个段的末尾写入不完整的记录，模拟写入时崩溃
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+segExt))
+	f, _ := os.OpenFile(paths[len(paths)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	s, err = Open(dir, 0, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, _, ok := s.Get("k1"); !ok || string(v) != "v3" {
+		t.Fatalf("reopen k1=v3 failed")
+	}
+	if _, _, ok := s.Get("k2"); ok {
+		t.Fatalf("deleted key k2 restored after reopen")
+	}
+}
+
+func TestRemoveOldestSegment(t *testing.T) {
+	rec := int64(headerLen + len("k0") + len("value"))
+	s, err := Open(t.TempDir(), rec*2, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Put("k0", []byte("value"), 0)
+	s.Put("k1", []byte("value"), 0)
+	s.Put("k2", []byte("value"), 0)
+	if _, _, ok := s.Get("k0"); ok || s.Len() != 2 {
+		t.Fatalf("remove oldest segment failed")
+	}
+	if s.Bytes() > rec*2 {
+		t.Fatalf("disk usage %d exceeds %d", s.Bytes(), rec*2)
+	}
+}
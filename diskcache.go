@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"cache/disk"
+	"cache/lru"
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//内存之下的磁盘缓存层，内存中被淘汰的数据会写入磁盘，读取时命中磁盘的数据会被重新提升到内存中，
+//写入磁盘的值的前8个字节为数据的版本
+
+// 磁盘中保存的值前面的版本的长度
+const diskVersionLen = 8
+
+// 为组打开磁盘缓存，磁盘缓存位于数据目录的disk/组名中
+func (g *Group) openDisk(diskBytes int64, segBytes int64) {
+	if diskBytes <= 0 {
+		return
+	}
+	// 组名不能安全地作为目录名时不开启磁盘缓存，删除组时也就不会删除数据目录之外的文件
+	if err := checkGroupName(g.name); err != nil {
+		log.Printf("[Zcache] disk cache of group %s disabled: %v", g.name, err)
+		return
+	}
+	s, err := disk.Open(g.diskDir(), diskBytes, segBytes)
+	if err != nil {
+		log.Printf("[Zcache] failed to open disk cache of group %s: %v", g.name, err)
+		return
+	}
+	g.diskCache = s
+	g.diskBytes = diskBytes
+	g.diskSegmentBytes = segBytes
+}
+
+func (g *Group) diskDir() string {
+	return dataPath(filepath.Join("disk", g.name))
+}
+
+// 内存中的数据被淘汰时的回调函数，开启磁盘缓存时将数据写入磁盘
+func (g *Group) evicted(key string, value lru.Value) {
+	g.Stats.Evictions.Add(1)
+	if g.diskCache != nil {
+		g.spill(key, value.(ByteView))
+	}
+}
+
+// 将未过期的数据写入磁盘
+func (g *Group) spill(key string, v ByteView) {
+	if v.expired(time.Now()) {
+		return
+	}
+	var expire int64
+	if !v.e.IsZero() {
+		expire = v.e.UnixNano()
+	}
+	b := binary.BigEndian.AppendUint64(make([]byte, 0, diskVersionLen+len(v.b)), v.v)
+	if err := g.diskCache.Put(key, append(b, v.b...), expire); err != nil {
+		log.Printf("[Zcache] failed to spill %s of group %s to disk: %v", key, g.name, err)
+	}
+}
+
+// 从磁盘中获取数据，命中时将数据从磁盘移动到内存中
+func (g *Group) getFromDisk(key string) (ByteView, bool) {
+	if g.diskCache == nil {
+		return ByteView{}, false
+	}
+	b, expire, ok := g.diskCache.Get(key)
+	if !ok || len(b) < diskVersionLen {
+		return ByteView{}, false
+	}
+	value := ByteView{b: b[diskVersionLen:], v: binary.BigEndian.Uint64(b)}
+	if expire != 0 {
+		value.e = time.Unix(0, expire)
+	}
+	// 读取磁盘之后内存中可能已经写入了更新的数据，此时使用内存中的数据，磁盘中的旧数据同样删除
+	value, _ = g.mainCache.addIfAbsent(key, value)
+	g.diskCache.Delete(key)
+	return value, true
+}
+
+func (g *Group) diskHas(key string) bool {
+	return g.diskCache != nil && g.diskCache.Has(key)
+}
+
+// 删除磁盘中的数据，在内存中的数据被更新或删除时调用，避免磁盘中的旧数据被重新读取
+func (g *Group) diskDelete(key string) bool {
+	return g.diskCache != nil && g.diskCache.Delete(key)
+}
+
+func (g *Group) diskKeys() []string {
+	if g.diskCache == nil {
+		return nil
+	}
+	return g.diskCache.Keys()
+}
+
+func (g *Group) diskClear() {
+	if g.diskCache == nil {
+		return
+	}
+	if err := g.diskCache.Clear(); err != nil {
+		log.Printf("[Zcache] failed to clear disk cache of group %s: %v", g.name, err)
+	}
+}
+
+// 关闭磁盘缓存，remove为true时同时删除磁盘上的文件
+func (g *Group) diskClose(remove bool) {
+	if g.diskCache == nil {
+		return
+	}
+	g.diskCache.Close()
+	if remove {
+		os.RemoveAll(g.diskDir())
+	}
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiskTier(t *testing.T) {
+	if err := SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDataDir(".")
+	// 内存只能容纳一个键值对
+	g := NewGroupWithInfo(GroupInfo{Name: "disk-test", CacheBytes: 6, DiskBytes: 1 << 20}, nil)
+	defer DeleteGroup(g.name)
+	g.Set("k1", ByteView{b: []byte("v1")})
+	g.mainCache.add("k1", ByteView{b: []byte("v1"), v: 7})
+	g.Set("k2", ByteView{b: []byte("v2")})
+	if _, ok := g.mainCache.get("k1"); ok {
+		t.Fatalf("k1 should be evicted from memory")
+	}
+	if !g.diskHas("k1") {
+		t.Fatalf("k1 should be spilled to disk")
+	}
+	if v, err := g.Get("k1"); err != nil || v.String() != "v1" || v.v != 7 {
+		t.Fatalf("get k1 from disk failed, got %v %v", v, err)
+	}
+	if _, ok := g.mainCache.get("k1"); !ok {
+		t.Fatalf("k1 should be promoted to memory")
+	}
+	if !g.diskHas("k2") || g.diskHas("k1") {
+		t.Fatalf("k2 should be spilled and k1 removed from disk after promotion")
+	}
+	if ok, _ := g.Delete("k2"); !ok || g.diskHas("k2") {
+		t.Fatalf("delete k2 from disk failed")
+	}
+}
+
+func TestDiskGroupName(t *testing.T) {
+	dir := t.TempDir()
+	if err := SetDataDir(dir + "/data"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDataDir(".")
+	// 组名指向数据目录之外时不开启磁盘缓存，删除组也不会删除外面的目录
+	g := NewGroupWithInfo(GroupInfo{Name: "..", CacheBytes: 6, DiskBytes: 1 << 20}, nil)
+	if g.diskCache != nil {
+		t.Fatal("expect disk cache to be disabled for an unsafe group name")
+	}
+	DeleteGroup(g.name)
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("directory outside the data dir removed: %v", err)
+	}
+	if err := ImportGroup(strings.NewReader(`{"name":"../x","cache_bytes":10,"num":0}`+"\n"), ""); err == nil {
+		t.Fatal("expect import of an unsafe group name to fail")
+	}
+}
+
+func TestDiskPromoteKeepsNewer(t *testing.T) {
+	if err := SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDataDir(".")
+	g := NewGroupWithInfo(GroupInfo{Name: "disk-promote-test", CacheBytes: 6, DiskBytes: 1 << 20}, nil)
+	defer DeleteGroup(g.name)
+	g.Set("k1", ByteView{b: []byte("v1")})
+	g.Set("k2", ByteView{b: []byte("v2")})
+	// 模拟读取磁盘之后并发写入的新数据
+	g.mainCache.add("k1", ByteView{b: []byte("n1")})
+	if v, ok := g.getFromDisk("k1"); !ok || v.String() != "n1" {
+		t.Fatalf("expect newer value in memory, got %v %v", v, ok)
+	}
+	if v, _ := g.mainCache.get("k1"); v.String() != "n1" {
+		t.Fatal("older value from disk overwrote the newer one")
+	}
+}
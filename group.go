@@ -2,49 +2,55 @@ package cache
 
 import (
 	"cache/cachepb/cachepb"
+	"cache/disk"
 	"cache/singleflight"
+	"context"
 	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"io"
 	"log"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //负责与外部交互，控制缓存存储和获取的主流程
 
-type groupInfo struct {
-	Name       []string `yaml:"name"`
-	CacheBytes []int64  `yaml:"cache-bytes"`
+// 组文件的文件名，位于数据目录中
+const (
+	groupsFileName = "groups.yml"
+	// 组未设置load-timeout时一次共享加载的最长时间
+	defaultLoadTimeout = 10 * time.Second
+)
+
+// 组文件的格式，groups中的每一项与持久化文件中的组信息使用同一个结构，
+// name与cache-bytes为旧版本的格式，仅在读取时兼容
+type groupsFile struct {
+	Groups     []GroupInfo `yaml:"groups"`
+	Name       []string    `yaml:"name,omitempty"`
+	CacheBytes []int64     `yaml:"cache-bytes,omitempty"`
 }
 
 // LoadGroups 加载组文件，将组信息导入
 func LoadGroups() {
 	fmt.Println("loading groups info...")
-	f, err := os.OpenFile("groups.yml", os.O_RDWR, 0644)
+	data, err := os.ReadFile(dataPath(groupsFileName))
 	if err != nil {
 		//未找到对应文件，创建新文件
-		f, err = os.Create("groups.yml")
-		if err != nil {
-			panic(err)
-		}
-		data, err := yaml.Marshal(groupInfo{
-			Name:       []string{"default"},
-			CacheBytes: []int64{2048},
+		data, err = yaml.Marshal(groupsFile{
+			Groups: []GroupInfo{{Name: "default", CacheBytes: defaultCacheBytes, Policy: PolicyLRU}},
 		})
 		if err != nil {
 			panic(err)
 		}
-		if _, err := f.Write(data); err != nil {
+		if err := os.WriteFile(dataPath(groupsFileName), data, 0644); err != nil {
 			panic(err)
 		}
 	}
-	data, err := io.ReadAll(f)
-	if err != nil {
-		panic(err)
-	}
-	g := groupInfo{}
+	g := groupsFile{}
 	if err := yaml.Unmarshal(data, &g); err != nil {
 		panic(err)
 	}
@@ -52,35 +58,24 @@ func LoadGroups() {
 		panic(errors.New("wrong groups file"))
 	}
 	for i := range len(g.Name) {
-		NewGroup(g.Name[i], g.CacheBytes[i], nil)
+		g.Groups = append(g.Groups, GroupInfo{Name: g.Name[i], CacheBytes: g.CacheBytes[i]})
+	}
+	for _, info := range g.Groups {
+		if info.Name == "" {
+			panic(errors.New("wrong groups file: group without name"))
+		}
+		NewGroupWithInfo(info, nil)
 	}
-	defer f.Close()
 }
 
+// UpdateGroupInfo 将当前所有组的信息写入组文件
 func UpdateGroupInfo() {
-	mu.Lock()
-	defer mu.Unlock()
-	g := groupInfo{
-		Name:       make([]string, len(groups)),
-		CacheBytes: make([]int64, len(groups)),
-	}
-	i := 0
-	for _, v := range groups {
-		g.Name[i] = v.name
-		g.CacheBytes[i] = v.mainCache.cacheBytes
-		i += 1
-	}
-	f, err := os.OpenFile("groups.yml", os.O_RDWR, 0644)
+	data, err := yaml.Marshal(groupsFile{Groups: GetGroupInfoList()})
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	data, err := yaml.Marshal(g)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	if _, err := f.Write(data); err != nil {
+	if err := os.WriteFile(dataPath(groupsFileName), data, 0644); err != nil {
 		fmt.Println(err)
 	}
 }
@@ -97,29 +92,143 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// ContextGetter 支持context的Getter，Group会优先调用GetContext，ctx的截止时间为组的load-timeout，
+// 不会因为某一个调用者取消而被取消
+type ContextGetter interface {
+	Getter
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// ContextGetterFunc 接口型函数
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
 type Group struct {
 	name      string
 	getter    Getter //用户设定的getter，在找不到对应数据时调用此回调函数在本地数据库中进行查找
 	mainCache cache
+	policy    string        //缓存淘汰策略
+	ttl       time.Duration //写入数据默认的过期时间，0表示永不过期
 	peers     PeerPicker
 	loader    *singleflight.Group //用来防止缓存穿透
+	batcher   *batcher            //getter实现了BatchGetter时，用于合并并发的加载
+	Stats     Stats               //组的统计信息
+
+	persistence     *bool //是否开启持久化，为nil时使用服务器的默认配置
+	persistenceTime int64 //持久化的间隔，单位为秒，0表示使用服务器的默认配置
+
+	diskCache        *disk.Store //磁盘缓存，为nil时表示未开启
+	diskBytes        int64
+	diskSegmentBytes int64
+
+	batchWindow int64
+	batchSize   int
+
+	negCache      *cache        //负缓存，为nil时表示未开启
+	negativeTTL   time.Duration //不存在的键在负缓存中保留的时间
+	negativeBytes int64
+
+	softTTL     time.Duration //从getter加载的数据的软过期时间，0表示不开启
+	refreshBeta float64       //提前刷新的系数，0表示不开启
+	loadTime    atomic.Int64  //最近一次通过getter加载的耗时，单位为纳秒
+	refreshMu   sync.Mutex
+	refreshing  map[string]bool //正在后台刷新的键
+
+	hedgeDelay   time.Duration //远程节点超过该时间未返回时发送对冲请求，0表示不开启
+	peerRetries  int           //请求远程节点失败时的最大重试次数
+	retryBackoff time.Duration //第一次重试之前等待的时间，之后每次翻倍
+
+	replicas int //每个键保存在哈希环上的几个节点上，大于1时可以按照一致性级别读写
+
+	loadTimeout time.Duration //一次共享加载的最长时间，0表示使用默认值
+
+	setter        Setter      //getter实现了Setter时，写入的数据同步到数据源
+	deleter       Deleter     //getter实现了Deleter时，删除的数据同步到数据源
+	writeMode     string      //写入数据源的模式，write-through或write-behind
+	writes        *writeQueue //write-behind模式下的写队列
+	writeInterval int64
+	writeBatch    int
 }
 
+// 没有代替负责节点的其他节点
+var errNoFallback = errors.New("no fallback peer")
+
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
+	// 所有组共用的远程节点选择器，通过RegisterPeerPicker设置
+	peerPicker PeerPicker
 )
 
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return NewGroupWithInfo(GroupInfo{Name: name, CacheBytes: cacheBytes}, getter)
+}
+
+// NewGroupWithInfo 根据组的元数据创建一个组，未设置的项使用默认值
+func NewGroupWithInfo(info GroupInfo, getter Getter) *Group {
+	if info.Policy == "" {
+		info.Policy = PolicyLRU
+	}
+	if info.Policy != PolicyLRU {
+		log.Printf("[Zcache] unsupported policy %q of group %s, use %s instead", info.Policy, info.Name, PolicyLRU)
+		info.Policy = PolicyLRU
+	}
 	mu.Lock()
-	defer mu.Unlock()
 	g := &Group{
-		name:      name,
+		name:      info.Name,
 		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
+		mainCache: cache{cacheBytes: info.CacheBytes},
+		policy:    info.Policy,
+		ttl:       time.Duration(info.TTL) * time.Second,
 		loader:    &singleflight.Group{},
+
+		persistence:     info.Persistence,
+		persistenceTime: info.PersistenceTime,
+
+		softTTL:     time.Duration(info.SoftTTL) * time.Second,
+		refreshBeta: info.RefreshBeta,
+		refreshing:  make(map[string]bool),
+
+		hedgeDelay:   time.Duration(info.HedgeDelay) * time.Millisecond,
+		peerRetries:  info.PeerRetries,
+		retryBackoff: time.Duration(info.RetryBackoff) * time.Millisecond,
+
+		replicas: info.Replicas,
+
+		loadTimeout: time.Duration(info.LoadTimeout) * time.Millisecond,
+	}
+	// 同名的组被替换时需要先关闭旧组的磁盘缓存
+	old := groups[info.Name]
+	if old != nil {
+		old.diskClose(false)
+	}
+	// 批量加载的配置总是保留，组目录与组文件中的配置不会因为getter不支持批量加载而丢失
+	g.batchWindow, g.batchSize = info.BatchWindow, info.BatchSize
+	if bg, ok := getter.(BatchGetter); ok {
+		g.batcher = newBatcher(bg, time.Duration(info.BatchWindow)*time.Millisecond, info.BatchSize)
+	}
+	g.mainCache.onEvicted = g.evicted
+	if info.Replicas > 1 {
+		g.mainCache.tombs = make(map[string]tombstone)
+	}
+	g.openDisk(info.DiskBytes, info.DiskSegmentBytes)
+	g.openNegative(info.NegativeTTL, info.NegativeBytes)
+	g.openStore(getter, info.WriteMode, info.WriteInterval, info.WriteBatch)
+	g.peers = peerPicker
+	groups[info.Name] = g
+	replicateGroup(g.Info(), false)
+	mu.Unlock()
+	// 旧组的写队列在释放锁之后再排空，数据源较慢时不会阻塞其他组的操作
+	if old != nil {
+		old.storeClose()
 	}
-	groups[name] = g
 	return g
 }
 
@@ -130,6 +239,43 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// Info 获得组的元数据
+func (g *Group) Info() GroupInfo {
+	return GroupInfo{
+		Name:       g.name,
+		CacheBytes: g.mainCache.maxBytes(),
+		Policy:     g.policy,
+		TTL:        int64(g.ttl / time.Second),
+
+		Persistence:     g.persistence,
+		PersistenceTime: g.persistenceTime,
+
+		DiskBytes:        g.diskBytes,
+		DiskSegmentBytes: g.diskSegmentBytes,
+
+		BatchWindow: g.batchWindow,
+		BatchSize:   g.batchSize,
+
+		NegativeTTL:   int64(g.negativeTTL / time.Second),
+		NegativeBytes: g.negativeBytes,
+
+		SoftTTL:     int64(g.softTTL / time.Second),
+		RefreshBeta: g.refreshBeta,
+
+		WriteMode:     g.writeMode,
+		WriteInterval: g.writeInterval,
+		WriteBatch:    g.writeBatch,
+
+		HedgeDelay:   int64(g.hedgeDelay / time.Millisecond),
+		PeerRetries:  g.peerRetries,
+		RetryBackoff: int64(g.retryBackoff / time.Millisecond),
+
+		Replicas: g.replicas,
+
+		LoadTimeout: int64(g.loadTimeout / time.Millisecond),
+	}
+}
+
 // RegisterPeers 注册一个用来选择远程节点的方法,将 实现了 PeerPicker 接口的 HTTPPool 注入到 Group 中
 func (g *Group) RegisterPeers(peers PeerPicker) {
 	if g.peers != nil {
@@ -138,95 +284,303 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 	g.peers = peers
 }
 
+// RegisterPeerPicker 为所有已经存在以及之后创建的组注册远程节点选择器
+func RegisterPeerPicker(peers PeerPicker) {
+	mu.Lock()
+	defer mu.Unlock()
+	if peerPicker != nil {
+		panic("RegisterPeerPicker called more than once")
+	}
+	peerPicker = peers
+	for _, g := range groups {
+		if g.peers == nil {
+			g.peers = peers
+		}
+	}
+}
+
 // Get 获取数据
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 获取数据，ctx被取消或者超时时立即返回，但是不会中止其他调用者共享的加载过程，
+// 共享的加载过程不受任何一个调用者的截止时间限制，而是使用组的load-timeout作为截止时间
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	return g.get(ctx, key, true)
+}
+
+// usePeers为false时未命中的数据直接在本地加载，用于处理其他节点转发过来的请求
+func (g *Group) get(ctx context.Context, key string, usePeers bool) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	g.Stats.Gets.Add(1)
 	if v, ok := g.mainCache.get(key); ok {
+		g.Stats.CacheHits.Add(1)
+		g.maybeRefresh(key, v)
 		return v, nil
 	}
+	// 内存中没有时检查磁盘缓存
+	if v, ok := g.getFromDisk(key); ok {
+		g.Stats.CacheHits.Add(1)
+		return v, nil
+	}
+	// 之前加载时不存在的键直接返回
+	if g.negativeHas(key) {
+		g.Stats.NegativeHits.Add(1)
+		return ByteView{}, ErrNotFound
+	}
 	// 如果在缓存中没有找到对应的数据，则从本地获取，通过用户设置的回调函数
-	return g.load(key)
+	g.Stats.Loads.Add(1)
+	return g.load(ctx, key, usePeers)
+}
+
+// 一次共享加载的最长时间
+func (g *Group) loadDeadline() time.Duration {
+	if g.loadTimeout <= 0 {
+		return defaultLoadTimeout
+	}
+	return g.loadTimeout
+}
+
+// 返回之后继续在后台进行的操作使用的context，只保留调用者的截止时间与值，不会因为调用者取消而被取消
+func detachContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	c := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(c, deadline)
+	}
+	return c, func() {}
 }
 
-// 当本地缓存中未命中时，会先尝试从远程节点中获取，如果也没有，则通过本地用户设置的回调函数中获取
-func (g *Group) load(key string) (value ByteView, err error) {
-	//确保只会调用一次
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
+// 当本地缓存中未命中时，会先尝试从远程节点中获取，远程节点不可用时由代替它的节点加载，如果都失败，
+// 则通过本地用户设置的回调函数中获取，usePeers为false时直接通过本地的getter获取，
+// ctx被取消或者超时时立即返回，但是不会中止其他调用者共享的加载过程
+func (g *Group) load(ctx context.Context, key string, usePeers bool) (ByteView, error) {
+	//确保只会调用一次，fn在单独的goroutine中执行，因此只使用自己的变量
+	viewi, err, _ := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+		// 共享的加载过程不使用第一个调用者的截止时间，否则其他没有截止时间的调用者也会超时，
+		// 而是使用组的加载超时，getter或者远程节点没有响应时不会一直占用该键
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), g.loadDeadline())
+		defer cancel()
+		g.Stats.LoadsDeduped.Add(1)
+		if usePeers && g.peers != nil {
 			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
+				value, err := g.getFromPeer(ctx, peer, key)
+				if err == nil {
+					g.Stats.PeerLoads.Add(1)
 					return value, nil
 				}
+				// 远程节点确认键不存在时不需要再从本地加载
+				if errors.Is(err, ErrNotFound) {
+					g.negativeAdd(key)
+					return nil, err
+				}
+				g.Stats.PeerErrors.Add(1)
 				log.Println("[GeeCache] Failed to get from peer", err)
+				// 代替节点也失败时直接返回错误，不在本地加载，否则每个节点都会访问一次数据源
+				value, err = g.getFromFallback(ctx, peer, key, err)
+				if !errors.Is(err, errNoFallback) {
+					return value, err
+				}
+			}
+		}
+		value, err := g.getLocally(ctx, key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				g.negativeAdd(key)
 			}
+			g.Stats.LocalLoadErrs.Add(1)
+			return nil, err
 		}
-		return g.getLocally(key)
+		g.Stats.LocalLoads.Add(1)
+		return value, nil
 	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return viewi.(ByteView), nil
+}
+
+// 负责key的节点不可用时，从代替它的节点获取数据，代替节点只由哈希环决定，所有节点会选出同一个代替节点，
+// 因此整个集群对同一个key仍然只有一次加载。代替节点是自己时返回errNoFallback，由调用者在本地加载，
+// 失败的节点已经是代替节点时(负责节点被熔断)直接返回它的错误peerErr
+func (g *Group) getFromFallback(ctx context.Context, failed PeerGetter, key string, peerErr error) (ByteView, error) {
+	fp, ok := g.peers.(FallbackPicker)
+	if !ok {
+		return ByteView{}, errNoFallback
+	}
+	peer, ok := fp.PickFallback(key)
+	if !ok {
+		return ByteView{}, errNoFallback
+	}
+	if h, ok := peer.(*HttpGetter); ok && h == failed {
+		return ByteView{}, peerErr
+	}
+	value, err := g.retryPeer(ctx, peer, key)
 	if err == nil {
-		return viewi.(ByteView), nil
+		g.Stats.PeerLoads.Add(1)
+		return value, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		g.negativeAdd(key)
+		return ByteView{}, err
 	}
-	return
+	g.Stats.PeerErrors.Add(1)
+	log.Println("[GeeCache] Failed to get from fallback peer", err)
+	return ByteView{}, err
 }
 
-// 从远程节点获得对应组的数据
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// 向远程节点发送一次请求获得对应组的数据，远程节点支持context时传递ctx
+func (g *Group) requestPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &cachepb.GetRequest{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &cachepb.Response{}
-	err := peer.Get(req, res)
+	var err error
+	if cp, ok := peer.(ContextPeerGetter); ok {
+		err = cp.GetContext(ctx, req, res)
+	} else {
+		err = peer.Get(req, res)
+	}
+	if errors.Is(err, ErrNotFound) {
+		peerRequests.WithLabelValues(peerAddr(peer), "not_found").Inc()
+		return ByteView{}, err
+	}
 	if err != nil {
+		peerRequests.WithLabelValues(peerAddr(peer), "error").Inc()
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	peerRequests.WithLabelValues(peerAddr(peer), "success").Inc()
+	return ByteView{b: res.Value, v: res.Version}, nil
 }
 
-// 通过用户设定的getter函数从源数据中获得数据，并放入缓存中
-func (g *Group) getLocally(key string) (ByteView, error) {
+// 通过用户设定的getter函数从源数据中获得数据，并放入缓存中，getter支持context时传递ctx，
+// getter支持批量获取时与其他并发的加载合并为一次调用
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
 	if g.getter == nil {
-		return ByteView{}, errors.New("FoundNoData")
+		return ByteView{}, ErrNotFound
+	}
+	// 还未写入数据源的数据比数据源中的数据更新
+	if b, del, ok := g.storePending(key); ok {
+		if del {
+			return ByteView{}, ErrNotFound
+		}
+		value := ByteView{b: cloneBytes(b)}
+		g.populateCache(key, value)
+		return value, nil
+	}
+	var bytes []byte
+	var err error
+	start := time.Now()
+	if g.batcher != nil {
+		bytes, err = g.batcher.get(ctx, key)
+	} else if cg, ok := g.getter.(ContextGetter); ok {
+		bytes, err = cg.GetContext(ctx, key)
+	} else {
+		bytes, err = g.getter.Get(key)
 	}
-	bytes, err := g.getter.Get(key)
 	if err != nil {
 		return ByteView{}, err
 	}
+	g.loadTime.Store(int64(time.Since(start)))
 	value := ByteView{b: cloneBytes(bytes)}
 	g.populateCache(key, value)
 	return value, nil
 }
 
 func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+	g.mainCache.add(key, g.withSoftTTL(g.withTTL(value)))
+}
+
+// 为没有设置过期时间的数据加上组默认的过期时间
+func (g *Group) withTTL(value ByteView) ByteView {
+	if g.ttl > 0 && value.e.IsZero() {
+		value.e = time.Now().Add(g.ttl)
+	}
+	return value
 }
 
-// Set 设置数据
-func (g *Group) Set(key string, value ByteView) {
+// Set 设置数据，getter实现了Setter时同时写入数据源，write-through模式下写入数据源失败时不会更新缓存
+func (g *Group) Set(key string, value ByteView) error {
 	//TODO 设置分布式节点的设置数据
+	if err := g.storeSet(key, value.b); err != nil {
+		return err
+	}
+	// 之后的Get不再等待写入之前开始的加载
+	g.loader.Forget(key)
+	g.diskDelete(key)
+	g.negativeDelete(key)
+	value = g.withTTL(value)
+	if value.v == 0 {
+		value.v = newVersion()
+	}
 	g.mainCache.add(key, value)
+	g.replicate(replSet, key, value)
+	return nil
 }
 
-// GetGroupKeyList 获得一个组中所有的键
+// GetGroupKeyList 获得一个组中所有的键，包括磁盘缓存中的键
 func (g *Group) GetGroupKeyList() []string {
-	return g.mainCache.getKeyList()
+	return append(g.mainCache.getKeyList(), g.diskKeys()...)
+}
+
+// SaveGroup 将组的元数据以及组中的数据进行数据持久化
+func (g *Group) SaveGroup(w io.Writer) error {
+	info := g.Info()
+	return g.mainCache.saveCache(w, &info, nil)
+}
+
+// Delete 删除组中所对应的键值，通过返回一个布尔值获取本地缓存中是否存在该键，其他节点缓存中的该键会通过失效广播删除，
+// getter实现了Deleter时同时从数据源中删除，write-through模式下从数据源删除失败时不会删除缓存
+func (g *Group) Delete(key string) (bool, error) {
+	if err := g.storeDelete(key); err != nil {
+		return false, err
+	}
+	return g.Invalidate(key), nil
+}
+
+// 只从缓存中删除，不会从数据源删除，删除使用新的版本，因此总是生效
+func (g *Group) deleteLocal(key string) bool {
+	deleted, _ := g.deleteVersion(key, newVersion())
+	return deleted
 }
 
-// SaveGroup 将组中的数据进行数据持久化
-func (g *Group) SaveGroup(f *os.File) error {
-	info := GroupInfo{}
-	info.Name = g.name
-	return g.mainCache.saveCache(f, &info)
+// 在缓存中的数据比version旧时删除key，多副本的组同时记录删除标记，返回是否删除了数据以及删除是否生效
+func (g *Group) deleteVersion(key string, version uint64) (deleted bool, applied bool) {
+	g.loader.Forget(key)
+	deleted, applied = g.mainCache.deleteVersion(key, version)
+	if !applied {
+		return false, false
+	}
+	deleted = g.diskDelete(key) || deleted
+	g.replicate(replDelete, key, ByteView{v: version})
+	return deleted, true
 }
 
-// Delete 删除组中所对应的键值，通过返回一个布尔值获取是否成功删除
-func (g *Group) Delete(key string) bool {
-	return g.mainCache.delete(key)
+// 清空组中的所有数据
+func (g *Group) clear() {
+	g.mainCache.clear()
+	g.diskClear()
+	g.negativeClear()
+	g.replicate(replClear, "", ByteView{})
 }
 
+// SetList 批量设置数据，越靠后的数据在LRU中越新，已经设置过期时间的数据保留原有的过期时间，
+// 只写入缓存，不会写入数据源
 func (g *Group) SetList(keys []string, values []ByteView) {
+	for i := range values {
+		values[i] = g.withTTL(values[i])
+	}
+	for _, key := range keys {
+		g.diskDelete(key)
+		g.negativeDelete(key)
+	}
 	g.mainCache.addList(keys, values)
+	for i, key := range keys {
+		g.replicate(replSet, key, values[i])
+	}
 }
 
 //---------------------------------------------------------------------------------------------------------------------
@@ -242,9 +596,32 @@ func GetGroupList() []string {
 	return res
 }
 
+// GetGroupInfoList 获得所有组的元数据，按照组名排序
+func GetGroupInfoList() []GroupInfo {
+	mu.RLock()
+	defer mu.RUnlock()
+	res := make([]GroupInfo, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, g.Info())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
 // DeleteGroup 删除组中的所有内容
 func DeleteGroup(groupName string) {
 	mu.Lock()
-	defer mu.Unlock()
+	g := groups[groupName]
+	if g != nil {
+		g.diskClose(true)
+		replicateGroup(GroupInfo{Name: groupName}, true)
+	}
 	delete(groups, groupName)
+	mu.Unlock()
+	// 在释放锁之后排空写队列
+	if g != nil {
+		g.storeClose()
+	}
 }
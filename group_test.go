@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGroupStats(t *testing.T) {
+	loads := 0
+	g := NewGroup("stats-test", 2048, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}))
+	defer DeleteGroup(g.name)
+	for range 3 {
+		if v, err := g.Get("k"); err != nil || v.String() != "k" {
+			t.Fatalf("get k failed, got %v %v", v, err)
+		}
+	}
+	s := g.GetStats()
+	if loads != 1 || s.Gets != 3 || s.CacheHits != 2 || s.Loads != 1 || s.LocalLoads != 1 {
+		t.Fatalf("unexpected stats %+v with %d loads", s, loads)
+	}
+	if s.Items != 1 || s.Bytes != int64(len("k")+len("k")) {
+		t.Fatalf("unexpected memory stats %+v", s)
+	}
+}
+
+func TestGetContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	loads := int32(0)
+	g := NewGroup("context-test", 2048, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		select {
+		case <-release:
+			return []byte(key), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+	defer DeleteGroup(g.name)
+
+	// 第一个调用者的截止时间很短，超时返回，但不会中止共享的加载过程
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(ctx, "k")
+		first <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		v, err := g.Get("k")
+		if err == nil && v.String() != "k" {
+			err = fmt.Errorf("unexpected value %q", v.String())
+		}
+		done <- err
+	}()
+	if err := <-first; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("shared load aborted: %v", err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expect 1 load, got %d", n)
+	}
+}
+
+func TestLoadTimeout(t *testing.T) {
+	deadlines := make(chan time.Duration, 1)
+	g := NewGroupWithInfo(GroupInfo{Name: "load-timeout-test", CacheBytes: 2048, LoadTimeout: 50}, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadlines <- -1
+		} else {
+			deadlines <- time.Until(deadline)
+		}
+		// 没有响应的数据源在加载超时之后被取消
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	defer DeleteGroup(g.name)
+	start := time.Now()
+	if _, err := g.Get("k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if d := <-deadlines; d <= 0 || d > 50*time.Millisecond {
+		t.Fatalf("expect the getter to see the load timeout, got %v", d)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("load not bounded by the load timeout")
+	}
+
+	// 从远程节点加载时剩余时间通过请求头传递
+	header := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header <- r.Header.Get(timeoutHeader)
+		body, _ := proto.Marshal(&cachepb.Response{Value: []byte("v")})
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+	g.peers = fallbackPicker{owner: &HttpGetter{BaseURL: srv.URL}}
+	if v, err := g.Get("p"); err != nil || v.String() != "v" {
+		t.Fatalf("get from peer failed: %v %v", v, err)
+	}
+	if ms, err := strconv.Atoi(<-header); err != nil || ms <= 0 || ms > 50 {
+		t.Fatalf("expect %s within the load timeout, got %v %v", timeoutHeader, ms, err)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	g := NewGroupWithInfo(GroupInfo{Name: "negative-test", CacheBytes: 2048, NegativeTTL: 60}, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, fmt.Errorf("no row %s: %w", key, ErrNotFound)
+	}))
+	defer DeleteGroup(g.name)
+	for range 3 {
+		if _, err := g.Get("k"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect not found, got %v", err)
+		}
+	}
+	if s := g.GetStats(); loads != 1 || s.NegativeHits != 2 {
+		t.Fatalf("unexpected stats %+v with %d loads", s, loads)
+	}
+	// 写入之后不再返回不存在
+	g.Set("k", ByteView{b: []byte("v")})
+	if v, err := g.Get("k"); err != nil || v.String() != "v" {
+		t.Fatalf("get k after set failed, got %v %v", v, err)
+	}
+	// 负缓存过期后重新加载
+	g.Delete("k")
+	g.negCache.add("k", ByteView{e: time.Now().Add(-time.Second)})
+	if _, err := g.Get("k"); !errors.Is(err, ErrNotFound) || loads != 2 {
+		t.Fatalf("expect reload after expire, got %v with %d loads", err, loads)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	loads := int32(0)
+	g := NewGroupWithInfo(GroupInfo{Name: "stale-test", CacheBytes: 2048, SoftTTL: 60}, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(fmt.Sprint(atomic.AddInt32(&loads, 1))), nil
+	}))
+	defer DeleteGroup(g.name)
+	if v, err := g.Get("k"); err != nil || v.String() != "1" {
+		t.Fatalf("get k failed, got %v %v", v, err)
+	}
+	// 软过期之后返回旧数据，并在后台刷新
+	g.mainCache.add("k", ByteView{b: []byte("1"), s: time.Now().Add(-time.Second)})
+	if v, err := g.Get("k"); err != nil || v.String() != "1" {
+		t.Fatalf("expect stale value, got %v %v", v, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := g.Get("k"); v.String() == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s := g.GetStats(); atomic.LoadInt32(&loads) != 2 || s.StaleHits < 1 || s.Refreshes != 1 {
+		t.Fatalf("unexpected stats %+v with %d loads", s, loads)
+	}
+	// 由其他节点负责的键从负责的节点刷新，不在本地访问数据源
+	g.peers = fallbackPicker{owner: peerFunc(func(in *cachepb.GetRequest, out *cachepb.Response) error {
+		out.Value = []byte("peer")
+		return nil
+	})}
+	g.mainCache.add("p", ByteView{b: []byte("old"), s: time.Now().Add(-time.Second)})
+	if v, err := g.Get("p"); err != nil || v.String() != "old" {
+		t.Fatalf("expect stale value, got %v %v", v, err)
+	}
+	for {
+		if v, _ := g.mainCache.get("p"); v.String() == "peer" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value not refreshed from peer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&loads) != 2 {
+		t.Fatalf("expect no local load, got %d loads", loads)
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	loads := int32(0)
+	g := NewGroupWithInfo(GroupInfo{Name: "early-refresh-test", CacheBytes: 2048, TTL: 60, RefreshBeta: 1}, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(fmt.Sprint(atomic.AddInt32(&loads, 1))), nil
+	}))
+	defer DeleteGroup(g.name)
+	g.loadTime.Store(int64(time.Hour))
+	// 远离过期时间的数据几乎不会被刷新，临近过期的数据会被提前刷新
+	g.mainCache.add("k", ByteView{b: []byte("0"), e: time.Now().Add(time.Second)})
+	if v, err := g.Get("k"); err != nil || v.String() != "0" {
+		t.Fatalf("get k failed, got %v %v", v, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&loads) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("value not refreshed early")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if g.shouldRefreshEarly(ByteView{e: time.Now().Add(1000 * time.Hour)}, time.Now()) {
+		t.Fatalf("refresh far from expiry")
+	}
+
+	// 写入的数据临近过期也不会被刷新
+	for v, _ := g.mainCache.get("k"); v.String() != "1"; v, _ = g.mainCache.get("k") {
+		time.Sleep(time.Millisecond)
+	}
+	g.loadTime.Store(int64(time.Hour))
+	refreshes := g.GetStats().Refreshes
+	g.Set("s", ByteView{b: []byte("set"), e: time.Now().Add(time.Second)})
+	for range 10 {
+		if v, err := g.Get("s"); err != nil || v.String() != "set" {
+			t.Fatalf("get s failed, got %v %v", v, err)
+		}
+	}
+	if s := g.GetStats(); s.Refreshes != refreshes {
+		t.Fatalf("written value refreshed, got %d refreshes", s.Refreshes-refreshes)
+	}
+}
+
+// 通过函数实现的远程节点
+type peerFunc func(in *cachepb.GetRequest, out *cachepb.Response) error
+
+func (f peerFunc) Get(in *cachepb.GetRequest, out *cachepb.Response) error {
+	return f(in, out)
+}
+
+// 所有键都由owner负责，owner不可用时由fallback代替
+type fallbackPicker struct {
+	owner, fallback PeerGetter
+}
+
+func (p fallbackPicker) PickPeer(string) (PeerGetter, bool) {
+	return p.owner, true
+}
+
+func (p fallbackPicker) PickFallback(string) (PeerGetter, bool) {
+	return p.fallback, p.fallback != nil
+}
+
+func TestFallbackPeer(t *testing.T) {
+	fallbackCalls := int32(0)
+	owner := peerFunc(func(*cachepb.GetRequest, *cachepb.Response) error {
+		return errors.New("connection refused")
+	})
+	fallback := peerFunc(func(in *cachepb.GetRequest, out *cachepb.Response) error {
+		atomic.AddInt32(&fallbackCalls, 1)
+		out.Value = []byte("from fallback")
+		return nil
+	})
+	loads := int32(0)
+	g := NewGroup("fallback-test", 2048, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("from origin"), nil
+	}))
+	defer DeleteGroup(g.name)
+	g.peers = fallbackPicker{owner: owner, fallback: fallback}
+	// 负责的节点不可用时由代替节点加载，而不是在本地访问数据源
+	if v, err := g.Get("k"); err != nil || v.String() != "from fallback" {
+		t.Fatalf("expect value from fallback, got %v %v", v, err)
+	}
+	if fallbackCalls != 1 || loads != 0 {
+		t.Fatalf("unexpected calls: fallback %d, origin %d", fallbackCalls, loads)
+	}
+	// 代替节点也不可用时返回错误，不在本地访问数据源
+	g.peers = fallbackPicker{owner: owner, fallback: owner}
+	if _, err := g.Get("k3"); err == nil || loads != 0 {
+		t.Fatalf("expect error without local load, got %v with %d loads", err, loads)
+	}
+	// 自己是代替节点时在本地加载
+	g.peers = fallbackPicker{owner: owner}
+	if v, err := g.Get("k2"); err != nil || v.String() != "from origin" || loads != 1 {
+		t.Fatalf("expect value from origin, got %v %v with %d loads", v, err, loads)
+	}
+}
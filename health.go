@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//远程节点的健康检查与熔断，连续失败的节点会被熔断，熔断期间选择节点时跳过它，由哈希环上的下一个健康节点代替，
+//熔断一段时间之后进入半开状态，允许一次试探请求，成功则恢复，失败则继续熔断，
+//后台的健康检查会定期访问每个节点的/Ready接口，结果同样会更新节点的状态
+
+const (
+	// 连续失败多少次之后熔断
+	breakerThreshold = 5
+	// 熔断之后多久进入半开状态，同时也是半开状态下试探请求的最长等待时间
+	breakerTimeout = 5 * time.Second
+	// 健康检查请求的超时时间
+	probeTimeout = time.Second
+)
+
+type breakerState int
+
+const (
+	stateClosed   breakerState = iota // 正常
+	stateOpen                         // 熔断
+	stateHalfOpen                     // 半开，允许一次试探请求
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// 一个远程节点的熔断器
+type breaker struct {
+	peer     string
+	pool     *HTTPPool
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // 连续失败的次数
+	since    time.Time // 进入熔断状态或者开始试探请求的时间
+}
+
+// 节点当前是否可以接收请求，只检查状态，不会占用半开状态下的试探请求，在持有锁的情况下调用，
+// 熔断或者试探请求没有返回结果时，超时之后允许新的请求
+func (b *breaker) ready() bool {
+	return b.state == stateClosed || time.Since(b.since) >= breakerTimeout
+}
+
+// 获得向节点发送请求的许可，熔断超时之后进入半开状态并占用唯一的试探请求，其他调用者在试探结束之前不被允许
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ready() {
+		return false
+	}
+	if b.state != stateClosed {
+		b.setState(stateHalfOpen)
+		b.since = time.Now()
+	}
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.setState(stateClosed)
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == stateHalfOpen || (b.state == stateClosed && b.failures >= breakerThreshold) {
+		b.setState(stateOpen)
+		b.since = time.Now()
+	}
+}
+
+// 在持有锁的情况下调用
+func (b *breaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	if b.pool != nil {
+		b.pool.Log("peer %s %s -> %s", b.peer, b.state, state)
+	}
+	b.state = state
+}
+
+// 根据请求的结果更新熔断器，连接失败等传输错误与5xx响应视为失败，调用者取消的请求不计入结果
+func (b *breaker) report(ctx context.Context, res *http.Response, err error) {
+	if b == nil {
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			b.failure()
+		}
+		return
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		b.failure()
+		return
+	}
+	b.success()
+}
+
+// PeerHealth 获得每个远程节点的熔断状态
+func (p *HTTPPool) PeerHealth() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make(map[string]string, len(p.breakers))
+	for peer, b := range p.breakers {
+		b.mu.Lock()
+		res[peer] = b.state.String()
+		b.mu.Unlock()
+	}
+	return res
+}
+
+// StartHealthCheck 每隔interval访问一次所有远程节点的/Ready接口，直到ctx被取消
+func (p *HTTPPool) StartHealthCheck(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.probeAll(ctx)
+			}
+		}
+	}()
+}
+
+func (p *HTTPPool) probeAll(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, getter := range p.otherPeers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			getter.probe(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// 访问节点的/Ready接口，结果会更新节点的熔断器
+func (h *HttpGetter) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.BaseURL+"/Ready", nil)
+	if err != nil {
+		return
+	}
+	res, err := h.HTTPClient().Do(req)
+	if err == nil {
+		// 读完响应之后连接才能被复用
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	// 健康检查自身超时也视为失败
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		h.breaker.failure()
+		return
+	}
+	h.breaker.report(ctx, res, err)
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 找到一个由peer负责的键
+func keyOwnedBy(t *testing.T, p *HTTPPool, peer string) string {
+	for i := range 1000 {
+		key := "key" + strconv.Itoa(i)
+		if p.owner(key) == peer {
+			return key
+		}
+	}
+	t.Fatalf("no key owned by %s", peer)
+	return ""
+}
+
+func TestPeerBreaker(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	self := "http://self"
+	p := NewHTTPPool(self)
+	p.Set(self, down.URL)
+	key := keyOwnedBy(t, p, down.URL)
+	if peer, ok := p.PickPeer(key); !ok || peer.(*HttpGetter).BaseURL != down.URL {
+		t.Fatalf("expect owner %s to be picked", down.URL)
+	}
+	// 连续失败之后熔断，由下一个健康的节点(自己)负责
+	for range breakerThreshold {
+		peer, _ := p.PickPeer(key)
+		if err := peer.Get(&cachepb.GetRequest{Group: "g", Key: key}, &cachepb.Response{}); err == nil {
+			t.Fatalf("expect error from closed server")
+		}
+	}
+	if _, ok := p.PickPeer(key); ok {
+		t.Fatalf("expect open breaker to be skipped")
+	}
+	if s := p.PeerHealth()[down.URL]; s != "open" {
+		t.Fatalf("expect open, got %s", s)
+	}
+	// 熔断超时之后允许一次试探请求，试探失败时继续熔断
+	b := p.breakers[down.URL]
+	b.since = time.Now().Add(-breakerTimeout)
+	peer, ok := p.PickPeer(key)
+	if !ok {
+		t.Fatalf("expect half-open breaker to allow a trial")
+	}
+	if _, ok := p.PickPeer(key); ok {
+		t.Fatalf("expect only one trial in half-open state")
+	}
+	_ = peer.Get(&cachepb.GetRequest{Group: "g", Key: key}, &cachepb.Response{})
+	if s := p.PeerHealth()[down.URL]; s != "open" {
+		t.Fatalf("expect open after failed trial, got %s", s)
+	}
+}
+
+func TestHealthProbe(t *testing.T) {
+	up := NewHTTPPool("http://up")
+	up.SetReady(true)
+	server := httptest.NewServer(up)
+	defer server.Close()
+	p := NewHTTPPool("http://self")
+	p.Set("http://self", server.URL)
+	b := p.breakers[server.URL]
+	for range breakerThreshold {
+		b.failure()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.StartHealthCheck(ctx, 5*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for p.PeerHealth()[server.URL] != "closed" {
+		if time.Now().After(deadline) {
+			t.Fatalf("breaker not closed by health probe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPickFallback(t *testing.T) {
+	self := "http://self"
+	p := NewHTTPPool(self)
+	p.Set(self, "http://a", "http://b", "http://c")
+	// 找到由a负责并且代替节点不是自己的键
+	var key string
+	var nodes []string
+	for i := 0; key == ""; i++ {
+		k := "key" + strconv.Itoa(i)
+		if n := p.peers.GetN(k, 2); n[0] == "http://a" && n[1] != self {
+			key, nodes = k, n
+		}
+	}
+	// 代替节点只由哈希环决定，不受熔断状态影响
+	for _, b := range p.breakers {
+		b.setState(stateOpen)
+		b.since = time.Now()
+	}
+	peer, ok := p.PickFallback(key)
+	if !ok || peer.(*HttpGetter).BaseURL != nodes[1] {
+		t.Fatalf("expect fallback %s, got %v", nodes[1], peer)
+	}
+	// 只有负责节点被熔断时PickPeer选择同一个代替节点
+	p.breakers[nodes[1]].setState(stateClosed)
+	if peer, ok := p.PickPeer(key); !ok || peer.(*HttpGetter).BaseURL != nodes[1] {
+		t.Fatalf("expect PickPeer to choose fallback %s, got %v", nodes[1], peer)
+	}
+	// 代替节点也被熔断时继续沿着哈希环选择下一个健康的节点
+	p.breakers[nodes[1]].setState(stateOpen)
+	next := p.peers.GetN(key, 3)[2]
+	if next != self {
+		p.breakers[next].setState(stateClosed)
+	}
+	peer, ok = p.PickPeer(key)
+	if next == self && ok || next != self && (!ok || peer.(*HttpGetter).BaseURL != next) {
+		t.Fatalf("expect PickPeer to choose %s, got %v", next, peer)
+	}
+}
+
+func TestBreakerCountsServerErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	p := NewHTTPPool("http://self")
+	p.Set("http://self", srv.URL)
+	getter := p.httpGetters[srv.URL]
+	// 5xx响应与传输错误一样计为失败
+	for range breakerThreshold {
+		_ = getter.Get(&cachepb.GetRequest{Group: "g", Key: "k"}, &cachepb.Response{})
+	}
+	if s := p.PeerHealth()[srv.URL]; s != "open" {
+		t.Fatalf("expect open after server errors, got %s", s)
+	}
+}
+
+func TestWarmStartRejectsData(t *testing.T) {
+	g := NewGroupWithInfo(GroupInfo{Name: "warm-test", CacheBytes: 2048}, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer DeleteGroup(g.name)
+	p := NewHTTPPool("http://self")
+	p.Set("http://self")
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	// 预热完成之前数据请求返回503
+	p.warming.Store(true)
+	for _, path := range []string{"/GetData?group=warm-test&key=k", "/DeleteData?group=warm-test&key=k"} {
+		if code := serve(path); code != http.StatusServiceUnavailable {
+			t.Fatalf("expect 503 for %s while warming, got %d", path, code)
+		}
+	}
+	p.WarmStart()
+	if code := serve("/Ready"); code != http.StatusOK {
+		t.Fatalf("expect ready after warm start, got %d", code)
+	}
+	if code := serve("/GetData?group=warm-test&key=k"); code != http.StatusOK {
+		t.Fatalf("expect data after warm start, got %d", code)
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+//对冲与重试，读取远程节点的数据是幂等的，因此失败时可以按照带随机抖动的指数退避进行重试，
+//开启对冲后远程节点超过一定时间没有返回时，同时向代替它的节点发送请求(没有代替节点时在本地加载)，使用最先成功的结果
+
+// 开启重试但是未设置退避时间时使用的默认值
+const defaultRetryBackoff = 10 * time.Millisecond
+
+// 从远程节点获得数据，失败时按照组的配置进行重试，开启对冲时远程节点超过hedgeDelay未返回则发送对冲请求，
+// 远程节点在对冲之前就失败时直接返回错误，由调用者决定如何处理
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
+	if g.hedgeDelay <= 0 {
+		return g.retryPeer(ctx, peer, key)
+	}
+	// 返回时取消还未完成的请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		value ByteView
+		err   error
+	}
+	ch := make(chan result, 2)
+	go func() {
+		value, err := g.retryPeer(ctx, peer, key)
+		ch <- result{value, err}
+	}()
+	timer := time.NewTimer(g.hedgeDelay)
+	defer timer.Stop()
+	pending := 1
+	var first error
+	for {
+		select {
+		case r := <-ch:
+			pending--
+			// 键不存在同样是确定的结果
+			if r.err == nil || errors.Is(r.err, ErrNotFound) {
+				return r.value, r.err
+			}
+			if first == nil {
+				first = r.err
+			}
+			if pending == 0 {
+				return ByteView{}, first
+			}
+		case <-timer.C:
+			pending++
+			g.Stats.Hedges.Add(1)
+			go func() {
+				value, err := g.hedge(ctx, key)
+				ch <- result{value, err}
+			}()
+		}
+	}
+}
+
+// 对冲请求，发给代替负责该键的节点，代替节点是自己时在本地加载
+func (g *Group) hedge(ctx context.Context, key string) (ByteView, error) {
+	if fp, ok := g.peers.(FallbackPicker); ok {
+		if peer, ok := fp.PickFallback(key); ok {
+			return g.requestPeer(ctx, peer, key)
+		}
+	}
+	return g.getLocally(ctx, key)
+}
+
+// 请求远程节点，失败时按照带随机抖动的指数退避进行重试，键不存在或者ctx结束时不再重试
+func (g *Group) retryPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
+	backoff := g.retryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for i := 0; ; i++ {
+		value, err := g.requestPeer(ctx, peer, key)
+		if err == nil || errors.Is(err, ErrNotFound) || i >= g.peerRetries || ctx.Err() != nil {
+			return value, err
+		}
+		g.Stats.PeerRetries.Add(1)
+		// 等待时间在[d/2, 3d/2)之间随机，避免多个节点同时重试
+		d := backoff << i
+		timer := time.NewTimer(d/2 + time.Duration(rand.Int63n(int64(d))))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ByteView{}, err
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgedPeerRequest(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := peerFunc(func(in *cachepb.GetRequest, out *cachepb.Response) error {
+		<-release
+		out.Value = []byte("from owner")
+		return nil
+	})
+	fallback := peerFunc(func(in *cachepb.GetRequest, out *cachepb.Response) error {
+		out.Value = []byte("from fallback")
+		return nil
+	})
+	g := NewGroupWithInfo(GroupInfo{Name: "hedge-test", CacheBytes: 2048, HedgeDelay: 10}, nil)
+	defer DeleteGroup(g.name)
+	g.peers = fallbackPicker{owner: slow, fallback: fallback}
+	start := time.Now()
+	if v, err := g.Get("k"); err != nil || v.String() != "from fallback" {
+		t.Fatalf("expect value from hedged request, got %v %v", v, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("hedged request took %v", d)
+	}
+	if s := g.GetStats(); s.Hedges != 1 {
+		t.Fatalf("expect 1 hedge, got %+v", s)
+	}
+}
+
+func TestPeerRetry(t *testing.T) {
+	calls := int32(0)
+	flaky := peerFunc(func(in *cachepb.GetRequest, out *cachepb.Response) error {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			return errors.New("connection reset")
+		}
+		out.Value = []byte("from owner")
+		return nil
+	})
+	g := NewGroupWithInfo(GroupInfo{Name: "retry-test", CacheBytes: 2048, PeerRetries: 2, RetryBackoff: 1}, nil)
+	defer DeleteGroup(g.name)
+	g.peers = fallbackPicker{owner: flaky}
+	if v, err := g.Get("k"); err != nil || v.String() != "from owner" {
+		t.Fatalf("expect value after retries, got %v %v", v, err)
+	}
+	if s := g.GetStats(); calls != 3 || s.PeerRetries != 2 || s.PeerErrors != 0 {
+		t.Fatalf("unexpected stats %+v with %d calls", s, calls)
+	}
+}
@@ -0,0 +1,367 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//提示移交(hinted handoff)，写入负责节点或者副本时对方不可用，收到请求的节点不会直接失败，
+//而是把这次写入作为提示保存在数据目录的hints目录中，每个节点一个文件，对方恢复健康之后按顺序重放给它。
+//提示带有写入时的版本，重放时对方只在版本更新时写入，因此不会覆盖之后直接写入对方的数据。
+//超过最长保留时间的提示会被丢弃，每个节点的提示超过最大空间时不再接受新的提示，写入返回原来的错误
+
+const (
+	// 提示默认的最长保留时间
+	defaultHintMaxAge = 3 * time.Hour
+	// 每个节点的提示默认的最大空间
+	defaultHintMaxBytes = 64 << 20
+	// 提示文件所在的目录，位于数据目录中
+	hintDir = "hints"
+	hintExt = ".jsonl"
+)
+
+// ErrHintsFull 节点的提示超过了最大空间
+var ErrHintsFull = errors.New("hints full")
+
+// Hint 一次没有送达的写入
+type Hint struct {
+	Group   string
+	Key     string
+	Value   []byte
+	Version uint64
+	Created time.Time
+}
+
+// 估算提示占用的空间
+func (h *Hint) size() int64 {
+	return int64(len(h.Group) + len(h.Key) + len(h.Value) + 64)
+}
+
+// HintStatus 提示移交的状态
+type HintStatus struct {
+	Pending  map[string]int // 每个节点等待重放的提示数量
+	Bytes    int64          // 所有提示占用的空间
+	Replayed int64          // 已经重放的提示数量
+	Dropped  int64          // 因为超过空间或者保留时间被丢弃的提示数量
+}
+
+type hintStore struct {
+	mu       sync.Mutex
+	maxAge   time.Duration
+	maxBytes int64
+	hints    map[string][]Hint // 每个节点的提示，按照写入的顺序
+	bytes    map[string]int64
+	replayed int64
+	dropped  int64
+}
+
+var hints = newHintStore()
+
+func newHintStore() *hintStore {
+	return &hintStore{
+		maxAge:   defaultHintMaxAge,
+		maxBytes: defaultHintMaxBytes,
+		hints:    make(map[string][]Hint),
+		bytes:    make(map[string]int64),
+	}
+}
+
+// SetHintedHandoff 设置提示的最长保留时间与每个节点的最大空间，并加载数据目录中保存的提示，为0的项使用默认值
+func SetHintedHandoff(maxAge time.Duration, maxBytes int64) error {
+	if maxAge <= 0 {
+		maxAge = defaultHintMaxAge
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultHintMaxBytes
+	}
+	hints.mu.Lock()
+	defer hints.mu.Unlock()
+	hints.maxAge = maxAge
+	hints.maxBytes = maxBytes
+	return hints.load()
+}
+
+// 获得节点的提示文件路径
+func hintPath(peer string) string {
+	return filepath.Join(dataPath(hintDir), url.QueryEscape(peer)+hintExt)
+}
+
+// 从数据目录中加载所有节点的提示并重置计数，在持有锁的情况下调用
+func (s *hintStore) load() error {
+	s.hints = make(map[string][]Hint)
+	s.bytes = make(map[string]int64)
+	s.replayed, s.dropped = 0, 0
+	files, err := os.ReadDir(dataPath(hintDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), hintExt)
+		if !ok {
+			continue
+		}
+		peer, err := url.QueryUnescape(name)
+		if err != nil {
+			continue
+		}
+		if err := s.loadPeer(peer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *hintStore) loadPeer(peer string) error {
+	f, err := os.Open(hintPath(peer))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		h := Hint{}
+		// 写入到一半的最后一行直接忽略
+		if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+			log.Printf("[Zcache] skip broken hint for %s: %v", peer, err)
+			continue
+		}
+		s.hints[peer] = append(s.hints[peer], h)
+		s.bytes[peer] += h.size()
+	}
+	return scanner.Err()
+}
+
+// 保存一条提示，先追加到文件中再加入内存
+func (s *hintStore) add(peer string, h Hint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bytes[peer]+h.size() > s.maxBytes {
+		s.dropped++
+		return ErrHintsFull
+	}
+	line, err := json.Marshal(&h)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataPath(hintDir), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(hintPath(peer), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	s.hints[peer] = append(s.hints[peer], h)
+	s.bytes[peer] += h.size()
+	return nil
+}
+
+// 获得节点的所有提示
+func (s *hintStore) pending(peer string) []Hint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Hint(nil), s.hints[peer]...)
+}
+
+// 获得有提示的节点
+func (s *hintStore) peers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]string, 0, len(s.hints))
+	for peer := range s.hints {
+		res = append(res, peer)
+	}
+	return res
+}
+
+// 移除节点最早的n条提示，replayed条为重放成功，其余为丢弃，并重写提示文件
+func (s *hintStore) remove(peer string, n int, replayed int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replayed += int64(replayed)
+	s.dropped += int64(n - replayed)
+	rest := s.hints[peer][min(n, len(s.hints[peer])):]
+	if len(rest) == 0 {
+		delete(s.hints, peer)
+		delete(s.bytes, peer)
+		if err := os.Remove(hintPath(peer)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	s.hints[peer] = rest
+	s.bytes[peer] = 0
+	f, err := os.CreateTemp(dataPath(hintDir), "hints.tmp*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	e := json.NewEncoder(w)
+	for i := range rest {
+		s.bytes[peer] += rest[i].size()
+		if err = e.Encode(&rest[i]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), hintPath(peer))
+}
+
+func (s *hintStore) status() HintStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := HintStatus{Pending: make(map[string]int, len(s.hints)), Replayed: s.replayed, Dropped: s.dropped}
+	for peer, list := range s.hints {
+		res.Pending[peer] = len(list)
+		res.Bytes += s.bytes[peer]
+	}
+	return res
+}
+
+// 判断错误是否表示节点不可用，只有连接失败或者超时等请求没有送达的情况才保存提示
+func unavailable(err error) bool {
+	var ue *url.Error
+	return errors.As(err, &ue) && !errors.Is(err, context.Canceled)
+}
+
+// 把发给peer失败的写入保存为提示，peer不是http节点时无法重放，返回原来的错误
+func (g *Group) handoff(peer PeerGetter, key string, value ByteView, err error) error {
+	addr := peerAddr(peer)
+	if !unavailable(err) || addr == "unknown" {
+		return err
+	}
+	if value.v == 0 {
+		value.v = newVersion()
+	}
+	h := Hint{Group: g.name, Key: key, Value: value.b, Version: value.v, Created: time.Now()}
+	if herr := hints.add(addr, h); herr != nil {
+		log.Printf("[Zcache] Failed to store hint for %s: %v", addr, herr)
+		return err
+	}
+	return nil
+}
+
+// HintStatus 获得提示移交的状态
+func (p *HTTPPool) HintStatus() HintStatus {
+	return hints.status()
+}
+
+// StartHintedHandoff 每隔interval把提示重放给已经恢复健康的节点，直到ctx被取消
+func (p *HTTPPool) StartHintedHandoff(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.replayHints(ctx)
+			}
+		}
+	}()
+}
+
+// 把提示按顺序重放给健康的节点，重放失败时停止，剩下的提示等待下一次重放
+func (p *HTTPPool) replayHints(ctx context.Context) {
+	hints.expire()
+	for _, peer := range hints.peers() {
+		p.mu.Lock()
+		getter, b := p.httpGetters[peer], p.breakers[peer]
+		p.mu.Unlock()
+		// 不在集群中的节点不会再恢复，它的提示等待过期
+		if getter == nil {
+			continue
+		}
+		b.mu.Lock()
+		healthy := b.state == stateClosed
+		b.mu.Unlock()
+		if !healthy {
+			continue
+		}
+		n, replayed := 0, 0
+		var err error
+		for _, h := range hints.pending(peer) {
+			g := GetGroup(h.Group)
+			if g == nil || time.Since(h.Created) > hints.age() {
+				n++
+				continue
+			}
+			if err = g.setReplica(ctx, getter, h.Key, ByteView{b: h.Value, v: h.Version}); err != nil {
+				break
+			}
+			n++
+			replayed++
+		}
+		if err != nil {
+			p.Log("replay hints to %s failed: %v", peer, err)
+		}
+		if n == 0 {
+			continue
+		}
+		if err := hints.remove(peer, n, replayed); err != nil {
+			p.Log("remove hints of %s failed: %v", peer, err)
+		}
+		if replayed > 0 {
+			p.Log("replayed %d hints to %s", replayed, peer)
+		}
+	}
+}
+
+// 把超过最长保留时间的提示从所有节点中移除，包括已经不在集群中的节点
+func (s *hintStore) expire() {
+	for _, peer := range s.peers() {
+		n := 0
+		for _, h := range s.pending(peer) {
+			if time.Since(h.Created) <= s.age() {
+				break
+			}
+			n++
+		}
+		if n > 0 {
+			if err := s.remove(peer, n, 0); err != nil {
+				log.Printf("[Zcache] Failed to expire hints of %s: %v", peer, err)
+			}
+		}
+	}
+}
+
+func (s *hintStore) age() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxAge
+}
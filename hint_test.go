@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHintedHandoff(t *testing.T) {
+	if err := SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDataDir(".")
+	if err := SetHintedHandoff(time.Hour, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	name := "hint-test"
+	g := NewGroupWithInfo(GroupInfo{Name: name, CacheBytes: 2048}, nil)
+	defer DeleteGroup(name)
+
+	// 节点不可用时直接断开连接
+	down := atomic.Bool{}
+	down.Store(true)
+	mu := sync.Mutex{}
+	received := map[string]uint64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := cachepb.SetRequest{}
+		_ = proto.Unmarshal(body, &req)
+		mu.Lock()
+		received[req.Key] = req.Version
+		mu.Unlock()
+		body, _ = proto.Marshal(&cachepb.Response{})
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+	p := NewHTTPPool("http://self")
+	p.Set("http://self", srv.URL)
+	g.peers = p
+
+	// 找到由远程节点负责的键
+	var keys []string
+	var values []ByteView
+	for i := 0; len(keys) < 3; i++ {
+		key := "k" + string(rune('a'+i))
+		if peer, ok := p.PickPeer(key); ok && peerAddr(peer) == srv.URL {
+			keys = append(keys, key)
+			values = append(values, ByteView{b: []byte(key)})
+		}
+	}
+	if err := g.SetMulti(context.Background(), keys, values); err != nil {
+		t.Fatalf("expect write to be hinted, got %v", err)
+	}
+	if s := p.HintStatus(); s.Pending[srv.URL] != 3 {
+		t.Fatalf("expect 3 hints, got %+v", s)
+	}
+
+	// 提示保存在数据目录中，重新加载之后仍然存在
+	if err := SetHintedHandoff(time.Hour, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.HintStatus(); s.Pending[srv.URL] != 3 {
+		t.Fatalf("expect hints to be reloaded, got %+v", s)
+	}
+
+	// 节点恢复之后重放提示
+	down.Store(false)
+	p.replayHints(context.Background())
+	s := p.HintStatus()
+	if len(s.Pending) != 0 || s.Replayed != 3 {
+		t.Fatalf("expect all hints replayed, got %+v", s)
+	}
+	mu.Lock()
+	for _, key := range keys {
+		if received[key] == 0 {
+			t.Fatalf("hint of %s not replayed with a version", key)
+		}
+	}
+	mu.Unlock()
+	if _, err := os.Stat(hintPath(srv.URL)); !os.IsNotExist(err) {
+		t.Fatalf("expect hint file to be removed, got %v", err)
+	}
+
+	// 超过保留时间的提示被丢弃，超过空间时不再保存
+	if err := SetHintedHandoff(time.Millisecond, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := hints.add(srv.URL, Hint{Group: name, Key: "old", Created: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hints.add(srv.URL, Hint{Group: name, Key: "big", Value: make([]byte, 200)}); err != ErrHintsFull {
+		t.Fatalf("expect ErrHintsFull, got %v", err)
+	}
+	p.replayHints(context.Background())
+	if s := p.HintStatus(); len(s.Pending) != 0 || s.Replayed != 0 || s.Dropped != 2 {
+		t.Fatalf("expect expired hint to be dropped, got %+v", s)
+	}
+}
@@ -1,34 +1,68 @@
 package cache
 
 import (
+	"bytes"
 	"cache/cachepb/cachepb"
 	"cache/consistenthash"
+	"cache/raft"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //提供被其他节点访问的能力(基于http)
 
 const (
 	defaultReplicas = 50
+	// 请求头中携带的请求剩余时间，单位为毫秒
+	timeoutHeader = "X-Zcache-Timeout"
+	// 由其他节点转发的请求会带上该请求头，收到的节点只在本地处理，不会再次转发
+	forwardedHeader = "X-Zcache-Forwarded"
+	// 获取的键不存在时响应中会带上该响应头，用来与组不存在等其他404错误区分
+	errorHeader   = "X-Zcache-Error"
+	errorNotFound = "not-found"
 )
 
 type HTTPPool struct {
 	self        string //用来记录自己的地址，包括主机名/IP 和端口
 	mu          sync.Mutex
-	peers       *consistenthash.Map    // 保存其他的peer节点，根据具体的key选择peer
-	httpGetters map[string]*HttpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	peers       *consistenthash.Map     // 保存其他的peer节点，根据具体的key选择peer
+	httpGetters map[string]*HttpGetter  // keyed by e.g. "http://10.0.0.2:8008"
+	breakers    map[string]*breaker     // 每个远程节点的熔断器
+	ready       atomic.Bool             // 节点是否已经完成启动，可以对外提供服务
+	warming     atomic.Bool             // 节点是否正在预热，预热期间不处理数据的读写请求
+	client      *http.Client            // 向其他节点发送请求使用的客户端
+	raft        *raft.Node              // 复制组目录与节点列表的raft节点，未开启raft时为nil
+	raftGetters map[string]*HttpGetter  // raft中的其他节点
+	replica     atomic.Pointer[replica] // 节点为副本时的复制状态
+	ae          antiEntropy             // 反熵修复的状态
 }
 
 func NewHTTPPool(self string) *HTTPPool {
+	return NewHTTPPoolWithClient(self, nil)
+}
+
+// NewHTTPPoolWithClient 创建一个使用client向其他节点发送请求的HTTPPool，client为nil时使用默认配置的客户端
+func NewHTTPPoolWithClient(self string, client *http.Client) *HTTPPool {
+	if client == nil {
+		client = NewHTTPClient(HTTPOptions{})
+	}
 	return &HTTPPool{
-		self: self,
+		self:   self,
+		client: client,
 	}
 }
 
@@ -38,21 +72,82 @@ func (p *HTTPPool) Log(format string, v ...interface{}) {
 
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/octet-stream")
-	p.Log("%s %s", r.Method, r.URL.Path)
 	parts := strings.SplitN(r.URL.Path, "/", 2)
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	// raft的心跳过于频繁，不记录日志
+	if parts[1] != "RaftAppend" {
+		p.Log("%s %s", r.Method, r.URL.Path)
+	}
 	q := r.URL.Query()
 	data, _ := io.ReadAll(r.Body)
 	method := parts[1]
+	// 记录请求的耗时，数据的读写请求分别记为Get与Set
+	start := time.Now()
+	op := method
+	defer func() {
+		requestDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}()
+	if p.forwardToLeader(w, r, method, data) {
+		return
+	}
+	// 副本只处理其他节点发送的批量失效通知，客户端的失效请求会触发广播，与写请求一样被拒绝
+	if p.readOnly() && (writeMethods[method] || method == "InvalidateBatch" && r.Header.Get(forwardedHeader) == "") {
+		http.Error(w, "read-only replica", http.StatusForbidden)
+		return
+	}
+	// 预热期间自己负责的数据还没有拉取完成，返回503，其他节点会把请求发给代替节点
+	if dataMethods[method] && p.warming.Load() {
+		http.Error(w, "warming up", http.StatusServiceUnavailable)
+		return
+	}
 	// 创建一个新的组
 	switch method {
+	case "metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = Metrics.WriteTo(w)
+		return
 	case "CreateGroup":
 		groupName := q.Get("group_name")
+		if err := checkGroupName(groupName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cacheBytes := int64(defaultCacheBytes)
+		if v := q.Get("cache_bytes"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid cache bytes: "+v, http.StatusBadRequest)
+				return
+			}
+			cacheBytes = n
+		}
 		fmt.Println("create group ", groupName)
-		NewGroup(groupName, 2048, nil)
+		// 组已经存在时保留其他配置，只修改容量
+		info := GroupInfo{Name: groupName, CacheBytes: cacheBytes}
+		if group := GetGroup(groupName); group != nil {
+			info = group.Info()
+			info.CacheBytes = cacheBytes
+		}
+		p.changeCatalog(w, r, info, false)
+		return
+	case "UpdateGroup":
+		groupName := q.Get("group")
+		group := GetGroup(groupName)
+		if group == nil {
+			http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+			return
+		}
+		n, err := strconv.ParseInt(q.Get("cache_bytes"), 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid cache bytes: "+q.Get("cache_bytes"), http.StatusBadRequest)
+			return
+		}
+		info := group.Info()
+		info.CacheBytes = n
+		p.changeCatalog(w, r, info, false)
 		return
 	case "GetGroups":
 		list := GetGroupList()
@@ -84,7 +179,12 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 			return
 		}
-		if !group.Delete(q.Get("key")) {
+		deleted, err := group.Delete(q.Get("key"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
 			http.Error(w, "delete failed", http.StatusNotFound)
 		}
 		return
@@ -94,11 +194,336 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "can't delete group default", http.StatusForbidden)
 			return
 		}
-		DeleteGroup(groupName)
+		p.changeCatalog(w, r, GroupInfo{Name: groupName}, true)
+		return
+	case "GetCatalog":
+		// 一致性读，leader确认自己仍然是leader并应用了之前提交的所有修改之后再返回
+		if p.raft != nil && q.Get("consistent") != "" {
+			if err := p.raft.Barrier(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		writeJSON(w, GetCatalog())
+		return
+	case "ApplyCatalog":
+		c := Catalog{}
+		if err := json.Unmarshal(data, &c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// 开启raft时目录只能通过raft修改
+		if p.raft != nil {
+			if err := p.proposeCatalog(r.Context(), c); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		if MergeCatalog(c) {
+			p.Log("catalog updated to version %d", c.Version)
+		}
+		return
+	case "SetPeers":
+		if p.raft == nil {
+			http.Error(w, "raft not enabled", http.StatusNotFound)
+			return
+		}
+		peers := strings.Split(q.Get("peers"), ",")
+		if slices.Contains(peers, "") {
+			http.Error(w, "invalid peers: "+q.Get("peers"), http.StatusBadRequest)
+			return
+		}
+		if err := p.propose(r.Context(), raftCommand{Op: raftOpPeers, Peers: peers}); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+		return
+	case "RaftStatus":
+		status, ok := p.RaftStatus()
+		if !ok {
+			http.Error(w, "raft not enabled", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, status)
+		return
+	case "ReplStream":
+		offset, err := strconv.ParseUint(q.Get("offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid offset: "+q.Get("offset"), http.StatusBadRequest)
+			return
+		}
+		replication.seen(q.Get("replica"), offset)
+		ctx, cancel := context.WithTimeout(r.Context(), replWaitTimeout)
+		defer cancel()
+		batch, ok := replication.since(ctx, q.Get("id"), offset)
+		if !ok {
+			http.Error(w, "full resync required", http.StatusGone)
+			return
+		}
+		writeJSON(w, batch)
+		return
+	case "ReplSnapshot":
+		writeReplSnapshot(w)
+		return
+	case "ReplStatus":
+		writeJSON(w, p.ReplStatus())
+		return
+	case "ReplPromote":
+		if !p.Promote() {
+			http.Error(w, "not a replica", http.StatusBadRequest)
+		}
+		return
+	case "MerkleTree":
+		ranges, ok := p.localRanges(q.Get("group"), q.Get("peer"))
+		if !ok {
+			http.Error(w, "no such replicated group: "+q.Get("group"), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, merkleTree(ranges))
+		return
+	case "MerkleRanges":
+		ranges, ok := p.localRanges(q.Get("group"), q.Get("peer"))
+		if !ok {
+			http.Error(w, "no such replicated group: "+q.Get("group"), http.StatusNotFound)
+			return
+		}
+		list, err := parseRanges(q.Get("ranges"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries := make([]MerkleEntry, 0)
+		for _, i := range list {
+			entries = append(entries, ranges[i]...)
+		}
+		writeJSON(w, entries)
+		return
+	case "AntiEntropy":
+		status, err := p.AntiEntropy(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, status)
+		return
+	case "AntiEntropyStatus":
+		writeJSON(w, p.AntiEntropyStatus())
+		return
+	case "Invalidate":
+		group := GetGroup(q.Get("group"))
+		if group == nil {
+			http.Error(w, "no such group: "+q.Get("group"), http.StatusNotFound)
+			return
+		}
+		if q.Get("key") == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		group.Invalidate(q.Get("key"))
+		return
+	case "InvalidateBatch":
+		var batch []Invalidation
+		if err := json.Unmarshal(data, &batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		applyInvalidations(batch)
+		return
+	case "Hints":
+		writeJSON(w, p.HintStatus())
+		return
+	case "RaftVote":
+		args := raft.VoteArgs{}
+		if p.raft == nil || json.Unmarshal(data, &args) != nil {
+			http.Error(w, "bad raft request", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, p.raft.HandleVote(&args))
+		return
+	case "RaftAppend":
+		args := raft.AppendArgs{}
+		if p.raft == nil || json.Unmarshal(data, &args) != nil {
+			http.Error(w, "bad raft request", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, p.raft.HandleAppend(&args))
+		return
+	case "SaveSnapshot":
+		info, err := SaveSnapshot(q.Get("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d, err := proto.Marshal(snapshotToPB(info))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(d)
+		return
+	case "ListSnapshots":
+		list, err := ListSnapshots()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out := &cachepb.SnapshotList{}
+		for _, v := range list {
+			out.Snapshots = append(out.Snapshots, snapshotToPB(v))
+		}
+		d, err := proto.Marshal(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(d)
+		return
+	case "RestoreSnapshot":
+		if err := RestoreSnapshot(q.Get("name"), q.Get("group")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	case "ExportGroup":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if err := ExportGroup(q.Get("group"), w); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	case "GetStats":
+		// group为空时返回所有组的统计信息
+		names := []string{q.Get("group")}
+		if names[0] == "" {
+			names = GetGroupList()
+			sort.Strings(names)
+		}
+		out := &cachepb.StatsList{}
+		for _, name := range names {
+			group := GetGroup(name)
+			if group == nil {
+				http.Error(w, "no such group: "+name, http.StatusNotFound)
+				return
+			}
+			out.Stats = append(out.Stats, statsToPB(group.GetStats()))
+		}
+		d, err := proto.Marshal(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(d)
+		return
+	case "MultiGet":
+		req := cachepb.MultiGetRequest{}
+		if err := proto.Unmarshal(data, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group := GetGroup(req.Group)
+		if group == nil {
+			http.Error(w, "no such group: "+req.Group, http.StatusNotFound)
+			return
+		}
+		ctx, cancel := requestContext(r)
+		defer cancel()
+		found, err := group.getMulti(ctx, req.Keys, r.Header.Get(forwardedHeader) == "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		out := &cachepb.MultiGetResponse{Entries: make([]*cachepb.KeyValue, 0, len(found))}
+		for _, key := range req.Keys {
+			if v, ok := found[key]; ok {
+				out.Entries = append(out.Entries, &cachepb.KeyValue{Key: key, Value: v.ByteSlice(), Version: v.v})
+				delete(found, key)
+			}
+		}
+		body, err := proto.Marshal(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(body)
+		return
+	case "MultiSet":
+		req := cachepb.MultiSetRequest{}
+		if err := proto.Unmarshal(data, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group := GetGroup(req.Group)
+		if group == nil {
+			http.Error(w, "no such group: "+req.Group, http.StatusNotFound)
+			return
+		}
+		keys := make([]string, len(req.Entries))
+		values := make([]ByteView, len(req.Entries))
+		for i, e := range req.Entries {
+			keys[i] = e.Key
+			values[i] = ByteView{b: e.Value}
+		}
+		ctx, cancel := requestContext(r)
+		defer cancel()
+		if err := group.setMulti(ctx, keys, values, r.Header.Get(forwardedHeader) == ""); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		body, err := proto.Marshal(&cachepb.Response{Value: []byte("create success")})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(body)
+		return
+	case "MultiDelete":
+		req := cachepb.MultiDeleteRequest{}
+		if err := proto.Unmarshal(data, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group := GetGroup(req.Group)
+		if group == nil {
+			http.Error(w, "no such group: "+req.Group, http.StatusNotFound)
+			return
+		}
+		ctx, cancel := requestContext(r)
+		defer cancel()
+		deleted, err := group.deleteMulti(ctx, req.Keys, r.Header.Get(forwardedHeader) == "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		body, err := proto.Marshal(&cachepb.MultiDeleteResponse{Deleted: deleted})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(body)
+		return
+	case "Ready":
+		if !p.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+		}
+		return
+	case "Dump":
+		// 导出组中由owner负责的数据，用于节点启动时的预热
+		owner := q.Get("owner")
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err := exportGroupFiltered(q.Get("group"), w, func(key string) bool {
+			return p.owner(key) == owner
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	case "ImportGroup":
+		if err := ImportGroup(bytes.NewReader(data), q.Get("group")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
+	op = r.Method
 	switch r.Method {
 	case "GET":
+		op = "Get"
 		groupName := q.Get("group")
 		key := q.Get("key")
 		group := GetGroup(groupName)
@@ -106,18 +531,48 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 			return
 		}
-		view, err := group.Get(key)
+		level, err := parseConsistency(q.Get("consistency"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := requestContext(r)
+		defer cancel()
+		var view ByteView
+		if forwarded := r.Header.Get(forwardedHeader) != ""; !forwarded && level != 0 {
+			view, err = group.GetConsistency(ctx, key, level)
+		} else {
+			view, err = group.get(ctx, key, !forwarded)
+		}
+		if errors.Is(err, ErrNotFound) {
+			w.Header().Set(errorHeader, errorNotFound)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		if errors.Is(err, ErrConsistency) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		body, err := proto.Marshal(&cachepb.Response{Value: view.ByteSlice()})
+		body, err := proto.Marshal(&cachepb.Response{Value: view.ByteSlice(), Version: view.v})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		_, _ = w.Write(body)
 	case "POST":
+		op = "Set"
+		if p.readOnly() {
+			http.Error(w, "read-only replica", http.StatusForbidden)
+			return
+		}
 		req := cachepb.SetRequest{}
 		_ = proto.Unmarshal(data, &req)
 		group := GetGroup(req.Group)
@@ -125,7 +580,26 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "no such group: "+req.Group, http.StatusNotFound)
 			return
 		}
-		group.Set(req.Key, ByteView{b: req.Value})
+		if _, ok := cachepb.Consistency_name[int32(req.Consistency)]; !ok {
+			http.Error(w, fmt.Sprintf("invalid consistency: %d", req.Consistency), http.StatusBadRequest)
+			return
+		}
+		// 协调节点同步给副本的数据只写入本地缓存
+		if r.Header.Get(forwardedHeader) != "" && req.Version != 0 {
+			group.setVersioned(req.Key, ByteView{b: req.Value, v: req.Version})
+		} else {
+			ctx, cancel := requestContext(r)
+			defer cancel()
+			err := group.SetConsistency(ctx, req.Key, ByteView{b: req.Value}, req.Consistency)
+			if errors.Is(err, ErrConsistency) {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		body, err := proto.Marshal(&cachepb.Response{Value: []byte("create success")})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -135,6 +609,98 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// 获得请求的context，请求头中带有剩余时间时为context设置对应的截止时间
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ms, err := strconv.ParseInt(r.Header.Get(timeoutHeader), 10, 64)
+	if err != nil {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), time.Duration(ms)*time.Millisecond)
+}
+
+// 解析请求中的一致性级别，为空时使用默认级别
+func parseConsistency(s string) (cachepb.Consistency, error) {
+	if s == "" {
+		return cachepb.Consistency_DEFAULT, nil
+	}
+	n, err := strconv.Atoi(s)
+	if _, ok := cachepb.Consistency_name[int32(n)]; err != nil || !ok {
+		return 0, fmt.Errorf("invalid consistency: %s", s)
+	}
+	return cachepb.Consistency(n), nil
+}
+
+// 修改数据或者组的请求，副本不会处理这些请求。其他节点发送的批量失效通知只删除缓存中的数据，副本同样需要处理
+var writeMethods = map[string]bool{
+	"CreateGroup":     true,
+	"UpdateGroup":     true,
+	"DeleteGroup":     true,
+	"DeleteData":      true,
+	"MultiSet":        true,
+	"MultiDelete":     true,
+	"RestoreSnapshot": true,
+	"ImportGroup":     true,
+	"ApplyCatalog":    true,
+	"SetPeers":        true,
+	"AntiEntropy":     true,
+	"Invalidate":      true,
+}
+
+// 数据的读写请求，节点预热期间不处理这些请求
+var dataMethods = map[string]bool{
+	"GetData":     true,
+	"DeleteData":  true,
+	"MultiGet":    true,
+	"MultiSet":    true,
+	"MultiDelete": true,
+}
+
+// 以JSON的格式写入响应
+func writeJSON(w http.ResponseWriter, v any) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(d)
+}
+
+func snapshotToPB(info SnapshotInfo) *cachepb.SnapshotInfo {
+	return &cachepb.SnapshotInfo{
+		Name:    info.Name,
+		Size:    info.Size,
+		ModTime: info.ModTime.Unix(),
+	}
+}
+
+func statsToPB(s StatsSnapshot) *cachepb.GroupStats {
+	return &cachepb.GroupStats{
+		Group:         s.Name,
+		Gets:          s.Gets,
+		CacheHits:     s.CacheHits,
+		Loads:         s.Loads,
+		LoadsDeduped:  s.LoadsDeduped,
+		PeerLoads:     s.PeerLoads,
+		PeerErrors:    s.PeerErrors,
+		LocalLoads:    s.LocalLoads,
+		LocalLoadErrs: s.LocalLoadErrs,
+		Evictions:     s.Evictions,
+		NegativeHits:  s.NegativeHits,
+		StaleHits:     s.StaleHits,
+		Refreshes:     s.Refreshes,
+		Writes:        s.Writes,
+		WriteErrors:   s.WriteErrors,
+		WritesDropped: s.WritesDropped,
+		PendingWrites: s.PendingWrites,
+		Hedges:        s.Hedges,
+		PeerRetries:   s.PeerRetries,
+		ReadRepairs:   s.ReadRepairs,
+		Bytes:         s.Bytes,
+		Items:         s.Items,
+	}
+}
+
 // Set 实例化了一致性哈希算法，并且添加了传入的节点
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
@@ -142,29 +708,155 @@ func (p *HTTPPool) Set(peers ...string) {
 	p.peers = consistenthash.New(defaultReplicas, nil)
 	p.peers.Add(peers...)
 	p.httpGetters = make(map[string]*HttpGetter, len(peers))
+	p.breakers = make(map[string]*breaker, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &HttpGetter{BaseURL: peer}
+		b := &breaker{peer: peer, pool: p}
+		p.breakers[peer] = b
+		p.httpGetters[peer] = &HttpGetter{BaseURL: peer, Client: p.client, isPeer: true, breaker: b}
 	}
 }
 
+// PickPeer 选择负责key的节点，该节点被熔断时沿着哈希环选择下一个健康的节点，遇到自己时在本地加载
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
-		p.Log("Pick peer %s", peer)
-		return p.httpGetters[peer], true
+	if p.peers == nil {
+		return nil, false
+	}
+	// 沿着哈希环找到第一个允许请求的节点，被熔断的节点由它之后的健康节点代替，
+	// 只有被选中的节点会占用半开状态下的试探请求
+	nodes := p.peers.GetN(key, len(p.httpGetters)+1)
+	for _, peer := range nodes {
+		if peer == p.self {
+			return nil, false
+		}
+		if b := p.breakers[peer]; b == nil || b.allow() {
+			p.Log("Pick peer %s", peer)
+			return p.httpGetters[peer], true
+		}
+	}
+	// 所有节点都被熔断时仍然交给负责的节点
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	p.Log("Pick peer %s", nodes[0])
+	return p.httpGetters[nodes[0]], true
+}
+
+// PickFallback 选择哈希环上紧跟在负责节点之后的节点，负责节点不可用时由它代替，
+// 代替节点只由哈希环决定，与各个节点的熔断状态无关，因此所有节点选出的代替节点相同
+func (p *HTTPPool) PickFallback(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if nodes := p.ring(key); len(nodes) > 1 && nodes[1] != p.self {
+		p.Log("Pick fallback peer %s", nodes[1])
+		return p.httpGetters[nodes[1]], true
 	}
 	return nil, false
 }
 
+// PickReplicas 选择哈希环上从key的位置开始的n个节点，不跳过被熔断的节点，由调用者按照一致性级别处理失败的节点
+func (p *HTTPPool) PickReplicas(key string, n int) ([]PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, true
+	}
+	var peers []PeerGetter
+	self := false
+	for _, node := range p.peers.GetN(key, n) {
+		if node == p.self {
+			self = true
+			continue
+		}
+		peers = append(peers, p.httpGetters[node])
+	}
+	return peers, self
+}
+
+// 获得哈希环上的负责节点以及紧跟在它之后的代替节点，在持有锁的情况下调用
+func (p *HTTPPool) ring(key string) []string {
+	if p.peers == nil {
+		return nil
+	}
+	return p.peers.GetN(key, 2)
+}
+
+// 获得负责key的节点，未设置节点时由自己负责
+func (p *HTTPPool) owner(key string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return p.self
+	}
+	return p.peers.Get(key)
+}
+
+// SetReady 设置节点是否已经就绪
+func (p *HTTPPool) SetReady(ready bool) {
+	p.ready.Store(ready)
+}
+
+// StartWarmStart 在后台进行预热，返回时节点已经处于预热状态，预热完成之前数据的读写请求返回503
+func (p *HTTPPool) StartWarmStart() {
+	p.warming.Store(true)
+	go p.WarmStart()
+}
+
+// WarmStart 从哈希环上的相邻节点拉取由自己负责的数据，通过SetList批量导入到对应的组中，完成后将节点标记为就绪。
+// 节点不在线时它负责的数据会落在这些相邻节点上，预热期间数据的读写请求返回503
+func (p *HTTPPool) WarmStart() {
+	p.warming.Store(true)
+	defer p.warming.Store(false)
+	p.mu.Lock()
+	getters := make([]*HttpGetter, 0)
+	if p.peers != nil {
+		for _, peer := range p.peers.Successors(p.self) {
+			getters = append(getters, p.httpGetters[peer])
+		}
+	}
+	p.mu.Unlock()
+	p.Log("warm start from %d peers", len(getters))
+	wg := sync.WaitGroup{}
+	for _, getter := range getters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, group := range GetGroupList() {
+				buf := bytes.Buffer{}
+				if err := getter.Dump(group, p.self, &buf); err != nil {
+					p.Log("warm start group %s from %s failed: %v", group, getter.BaseURL, err)
+					continue
+				}
+				if _, err := loadPersistence(&buf, loadOptions{group: group, skipDeleted: true}); err != nil {
+					p.Log("warm start group %s from %s failed: %v", group, getter.BaseURL, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	p.Log("warm start complete")
+	p.SetReady(true)
+}
+
 var _ PeerPicker = (*HTTPPool)(nil)
+var _ FallbackPicker = (*HTTPPool)(nil)
 
 // HttpGetter http客户端，实现了PeerGetter接口
 type HttpGetter struct {
 	BaseURL string
+	Client  *http.Client // 发送请求使用的客户端，为nil时使用http.DefaultClient
+	isPeer  bool         // 是否为集群中的节点，节点发出的请求会带上forwardedHeader
+	breaker *breaker     // 节点的熔断器，不为nil时请求的结果会更新熔断器
 }
 
 func (h *HttpGetter) Get(in *cachepb.GetRequest, out *cachepb.Response) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+// GetContext 获取数据，ctx的剩余时间通过X-Zcache-Timeout请求头传递给远程节点，
+// 通过Group加载时为组的load-timeout的剩余时间
+func (h *HttpGetter) GetContext(ctx context.Context, in *cachepb.GetRequest, out *cachepb.Response) error {
 	u := fmt.Sprintf(
 		"%v/%v?key=%v&group=%v",
 		h.BaseURL,
@@ -172,11 +864,28 @@ func (h *HttpGetter) Get(in *cachepb.GetRequest, out *cachepb.Response) error {
 		url.QueryEscape(in.GetKey()),
 		url.QueryEscape(in.GetGroup()),
 	)
-	res, err := http.Get(u)
+	if in.GetConsistency() != cachepb.Consistency_DEFAULT {
+		u += "&consistency=" + strconv.Itoa(int(in.GetConsistency()))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(timeoutHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+	// 收到请求的节点负责或者代替负责该键，直接在本地加载
+	if h.isPeer {
+		req.Header.Set(forwardedHeader, "1")
+	}
+	res, err := h.do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	if res.Header.Get(errorHeader) == errorNotFound {
+		return ErrNotFound
+	}
 	if res.StatusCode != http.StatusOK {
 		s, _ := io.ReadAll(res.Body)
 		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
@@ -191,4 +900,102 @@ func (h *HttpGetter) Get(in *cachepb.GetRequest, out *cachepb.Response) error {
 	return nil
 }
 
-var _ PeerGetter = (*HttpGetter)(nil)
+// SetVersioned 向副本写入带版本的数据
+func (h *HttpGetter) SetVersioned(ctx context.Context, in *cachepb.SetRequest, out *cachepb.Response) error {
+	return h.post(ctx, "GetData", in, out)
+}
+
+// GetMulti 批量获取数据
+func (h *HttpGetter) GetMulti(ctx context.Context, in *cachepb.MultiGetRequest, out *cachepb.MultiGetResponse) error {
+	return h.post(ctx, "MultiGet", in, out)
+}
+
+// SetMulti 批量设置数据
+func (h *HttpGetter) SetMulti(ctx context.Context, in *cachepb.MultiSetRequest, out *cachepb.Response) error {
+	return h.post(ctx, "MultiSet", in, out)
+}
+
+// DeleteMulti 批量删除数据
+func (h *HttpGetter) DeleteMulti(ctx context.Context, in *cachepb.MultiDeleteRequest, out *cachepb.MultiDeleteResponse) error {
+	return h.post(ctx, "MultiDelete", in, out)
+}
+
+// 以POST的方式发送proto格式的请求，并将响应解码到out中
+func (h *HttpGetter) post(ctx context.Context, method string, in proto.Message, out proto.Message) error {
+	data, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.BaseURL+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(timeoutHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+	if h.isPeer {
+		req.Header.Set(forwardedHeader, "1")
+	}
+	res, err := h.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if err = proto.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// HTTPClient 获得发送请求使用的客户端
+func (h *HttpGetter) HTTPClient() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+// 发送请求，并根据结果更新节点的熔断器
+func (h *HttpGetter) do(req *http.Request) (*http.Response, error) {
+	res, err := h.HTTPClient().Do(req)
+	h.breaker.report(req.Context(), res, err)
+	return res, err
+}
+
+var _ ContextPeerGetter = (*HttpGetter)(nil)
+var _ MultiPeerGetter = (*HttpGetter)(nil)
+
+// Dump 获得远程节点的组中由owner负责的数据，以JSON Lines的格式写入w中
+func (h *HttpGetter) Dump(group string, owner string, w io.Writer) error {
+	u := fmt.Sprintf(
+		"%v/%v?group=%v&owner=%v",
+		h.BaseURL,
+		"Dump",
+		url.QueryEscape(group),
+		url.QueryEscape(owner),
+	)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := h.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
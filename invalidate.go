@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//失效广播，删除或者主动失效一个键时，除了删除本地的数据，还会通知集群中的所有其他节点删除它们缓存中的这个键，
+//包括其他节点代替加载时保存的数据以及多副本时的副本。通知在后台按批发送，每条通知带有唯一的ID与删除时的版本，
+//节点会记住最近收到的ID，重试或者重复收到的通知只会应用一次；通知只删除版本比它旧的数据，
+//因此迟到的通知(包括第一次送达就迟到的通知)不会删除之后写入的新数据，从getter加载的数据没有版本，总是被删除
+
+const (
+	// 批量发送失效通知的间隔
+	invalidateInterval = 10 * time.Millisecond
+	// 一批失效通知的最大数量，达到之后立即发送
+	invalidateBatchSize = 512
+	// 发送一批失效通知失败时的重试次数
+	invalidateRetries = 3
+	// 记住的最近的失效通知ID的数量
+	invalidateSeenSize = 1 << 16
+)
+
+// Invalidation 一条失效通知
+type Invalidation struct {
+	ID      string
+	Group   string
+	Key     string
+	Version uint64 `json:",omitempty"` // 删除的版本，只删除比它旧的数据，0表示无条件删除
+}
+
+type invalidator struct {
+	mu      sync.Mutex
+	prefix  string // 本节点生成的ID的前缀，每次启动时随机生成
+	seq     uint64
+	enabled bool // 开始广播之后才记录通知
+	pending []Invalidation
+	notify  chan struct{}
+	seen    map[string]bool
+	order   []string // 按照收到的顺序记录ID，超过数量时忘记最早的ID
+	next    int
+}
+
+var invalidations = newInvalidator()
+
+func newInvalidator() *invalidator {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return &invalidator{
+		prefix: hex.EncodeToString(b),
+		notify: make(chan struct{}, 1),
+		seen:   make(map[string]bool),
+		order:  make([]string, invalidateSeenSize),
+	}
+}
+
+// 记录一条需要广播的失效通知
+func (v *invalidator) enqueue(group, key string, version uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.enabled {
+		return
+	}
+	id := v.newID()
+	v.pending = append(v.pending, Invalidation{ID: id, Group: group, Key: key, Version: version})
+	if len(v.pending) >= invalidateBatchSize {
+		select {
+		case v.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 生成一个新的通知ID，其他节点转发回来的自己的通知直接忽略，在持有锁的情况下调用
+func (v *invalidator) newID() string {
+	v.seq++
+	id := v.prefix + "-" + strconv.FormatUint(v.seq, 10)
+	v.remember(id)
+	return id
+}
+
+// 生成一个新的通知ID，用于直接发送给某个节点的通知
+func (v *invalidator) nextID() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.newID()
+}
+
+// 取出一批等待发送的通知
+func (v *invalidator) take() []Invalidation {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n := min(len(v.pending), invalidateBatchSize)
+	batch := v.pending[:n:n]
+	v.pending = v.pending[n:]
+	return batch
+}
+
+// 记住一个ID，已经记住过时返回false，在持有锁的情况下调用
+func (v *invalidator) remember(id string) bool {
+	if v.seen[id] {
+		return false
+	}
+	delete(v.seen, v.order[v.next])
+	v.order[v.next] = id
+	v.next = (v.next + 1) % len(v.order)
+	v.seen[id] = true
+	return true
+}
+
+// 应用其他节点发送的失效通知，只删除本地缓存中的数据，不会再次广播，返回实际应用的通知数量
+func applyInvalidations(batch []Invalidation) int {
+	applied := 0
+	for _, inv := range batch {
+		invalidations.mu.Lock()
+		fresh := invalidations.remember(inv.ID)
+		invalidations.mu.Unlock()
+		if !fresh {
+			continue
+		}
+		g := GetGroup(inv.Group)
+		switch {
+		case g == nil:
+		case inv.Version != 0:
+			g.deleteVersion(inv.Key, inv.Version)
+		default:
+			g.deleteLocal(inv.Key)
+		}
+		applied++
+	}
+	return applied
+}
+
+// Invalidate 使所有节点缓存中的key失效，不会从数据源删除，之后的读取会重新加载，返回本地是否存在该键
+func (g *Group) Invalidate(key string) bool {
+	version := newVersion()
+	deleted, _ := g.deleteVersion(key, version)
+	invalidations.enqueue(g.name, key, version)
+	return deleted
+}
+
+// StartInvalidation 开始向其他节点广播失效通知，直到ctx被取消
+func (p *HTTPPool) StartInvalidation(ctx context.Context) {
+	invalidations.mu.Lock()
+	invalidations.enabled = true
+	invalidations.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(invalidateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-invalidations.notify:
+			}
+			for batch := invalidations.take(); len(batch) > 0; batch = invalidations.take() {
+				p.broadcastInvalidations(ctx, batch)
+			}
+		}
+	}()
+}
+
+// 把一批失效通知发送给所有其他节点，失败时按照退避进行重试
+func (p *HTTPPool) broadcastInvalidations(ctx context.Context, batch []Invalidation) {
+	wg := sync.WaitGroup{}
+	for _, getter := range p.otherPeers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			backoff := 50 * time.Millisecond
+			var err error
+			for i := 0; i <= invalidateRetries; i++ {
+				if err = getter.Invalidate(ctx, batch); err == nil {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+			p.Log("send %d invalidations to %s failed: %v", len(batch), getter.BaseURL, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// Invalidate 向远程节点发送一批失效通知
+func (h *HttpGetter) Invalidate(ctx context.Context, batch []Invalidation) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.BaseURL+"/InvalidateBatch", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// 失效通知只在节点之间发送，只读副本只处理带有该请求头的通知
+	req.Header.Set(forwardedHeader, "1")
+	return h.doJSON(req, nil)
+}
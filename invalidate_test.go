@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestApplyInvalidations(t *testing.T) {
+	g := NewGroupWithInfo(GroupInfo{Name: "invalidate-apply-test", CacheBytes: 2048}, nil)
+	defer DeleteGroup(g.name)
+	g.Set("k", ByteView{b: []byte("v1")})
+	batch := []Invalidation{{ID: "peer-" + strconv.FormatInt(time.Now().UnixNano(), 10), Group: g.name, Key: "k"}}
+	if n := applyInvalidations(batch); n != 1 {
+		t.Fatalf("expect 1 applied invalidation, got %d", n)
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Fatal("key not invalidated")
+	}
+	// 重复的通知不会删除之后写入的数据
+	g.Set("k", ByteView{b: []byte("v2")})
+	if n := applyInvalidations(batch); n != 0 {
+		t.Fatalf("expect duplicate to be ignored, got %d", n)
+	}
+	if v, ok := g.mainCache.get("k"); !ok || v.String() != "v2" {
+		t.Fatal("duplicate invalidation removed newer data")
+	}
+	// 第一次送达就迟到的通知只删除比它旧的数据
+	old := newVersion()
+	g.Set("k", ByteView{b: []byte("v3")})
+	late := []Invalidation{{ID: "peer-late-" + strconv.FormatInt(time.Now().UnixNano(), 10), Group: g.name, Key: "k", Version: old}}
+	if n := applyInvalidations(late); n != 1 {
+		t.Fatalf("expect late invalidation to be applied, got %d", n)
+	}
+	if v, ok := g.mainCache.get("k"); !ok || v.String() != "v3" {
+		t.Fatal("late invalidation removed newer data")
+	}
+}
+
+func TestInvalidationBroadcast(t *testing.T) {
+	g := NewGroupWithInfo(GroupInfo{Name: "invalidate-test", CacheBytes: 2048}, nil)
+	defer DeleteGroup(g.name)
+	received := make(chan []Invalidation, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Invalidation
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &batch)
+		received <- batch
+	}))
+	defer srv.Close()
+
+	p := NewHTTPPool("http://self")
+	p.Set("http://self", srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		invalidations.mu.Lock()
+		invalidations.enabled = false
+		invalidations.pending = nil
+		invalidations.mu.Unlock()
+	}()
+	p.StartInvalidation(ctx)
+
+	g.Set("k1", ByteView{b: []byte("v1")})
+	if ok, err := g.Delete("k1"); !ok || err != nil {
+		t.Fatalf("delete failed: %v %v", ok, err)
+	}
+	g.Invalidate("k2")
+	keys := map[string]bool{}
+	ids := map[string]bool{}
+	timeout := time.After(time.Second)
+	for len(keys) < 2 {
+		select {
+		case batch := <-received:
+			for _, inv := range batch {
+				keys[inv.Key] = true
+				ids[inv.ID] = true
+			}
+		case <-timeout:
+			t.Fatalf("invalidations not broadcast, got %v", keys)
+		}
+	}
+	if !keys["k1"] || !keys["k2"] || len(ids) != 2 {
+		t.Fatalf("unexpected invalidations %v %v", keys, ids)
+	}
+}
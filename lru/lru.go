@@ -3,7 +3,6 @@ package lru
 
 import (
 	"container/list"
-	"fmt"
 )
 
 type Cache struct {
@@ -42,7 +41,6 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 		kv := ele.Value.(*Entry)
 		return kv.Value, true
 	}
-	fmt.Println(c.cache)
 	return
 }
 
@@ -85,10 +83,18 @@ func (c *Cache) Delete(key string) bool {
 	}
 	delete(c.cache, key)
 	c.ll.Remove(ele)
-	c.nbytes += int64(ele.Value.(*Entry).Value.Len()) + int64(len(key))
+	c.nbytes -= int64(ele.Value.(*Entry).Value.Len()) + int64(len(key))
 	return true
 }
 
+// SetMaxBytes 修改允许使用的最大内存，超出时淘汰最近最少访问的节点
+func (c *Cache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
 // Len the number of cache entries
 func (c *Cache) Len() int {
 	if c == nil {
@@ -97,6 +103,14 @@ func (c *Cache) Len() int {
 	return c.ll.Len()
 }
 
+// Bytes 当前使用的内存
+func (c *Cache) Bytes() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.nbytes
+}
+
 // GetKeyList 获得所有键的列表
 func (c *Cache) GetKeyList() []string {
 	if c == nil {
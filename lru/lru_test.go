@@ -53,3 +53,25 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestDelete(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+	if !lru.Delete("key1") || lru.Len() != 1 {
+		t.Fatalf("delete key1 failed")
+	}
+	if lru.Bytes() != int64(len("key2")+len("5678")) {
+		t.Fatalf("expect %d bytes after delete, got %d", len("key2")+len("5678"), lru.Bytes())
+	}
+}
+
+func TestSetMaxBytes(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	lru.SetMaxBytes(4)
+	if _, ok := lru.Get("k1"); ok || lru.Len() != 1 || lru.Bytes() != 4 {
+		t.Fatalf("shrink failed, len %d bytes %d", lru.Len(), lru.Bytes())
+	}
+}
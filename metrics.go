@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"cache/metrics"
+	"sort"
+	"time"
+)
+
+//服务器的监控指标，通过/metrics接口以Prometheus文本格式输出
+
+var (
+	// Metrics 服务器的所有指标
+	Metrics = metrics.NewRegistry()
+
+	requestDuration = metrics.NewHistogramVec(
+		"zcache_request_duration_seconds",
+		"Latency of requests handled by the server.",
+		metrics.DefBuckets,
+		"method",
+	)
+	peerRequests = metrics.NewCounterVec(
+		"zcache_peer_requests_total",
+		"Requests sent to peers by outcome.",
+		"peer", "result",
+	)
+	persistenceDuration = metrics.NewHistogramVec(
+		"zcache_persistence_duration_seconds",
+		"Duration of persistence operations.",
+		[]float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+		"operation",
+	)
+)
+
+func init() {
+	Metrics.Register(metrics.CollectorFunc(collectGroups))
+	Metrics.Register(requestDuration)
+	Metrics.Register(peerRequests)
+	Metrics.Register(persistenceDuration)
+	Metrics.Register(metrics.RuntimeCollector)
+}
+
+// 记录一次持久化操作所花费的时间
+func observePersistence(operation string, start time.Time) {
+	persistenceDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// 获得远程节点的地址，用于指标中的标签
+func peerAddr(peer PeerGetter) string {
+	if h, ok := peer.(*HttpGetter); ok {
+		return h.BaseURL
+	}
+	return "unknown"
+}
+
+// 组的指标在抓取时从组的统计信息中读取
+var groupCounters = []struct {
+	name string
+	help string
+	get  func(s *StatsSnapshot) int64
+}{
+	{"zcache_group_gets_total", "Get requests, including requests from peers.", func(s *StatsSnapshot) int64 { return s.Gets }},
+	{"zcache_group_cache_hits_total", "Gets served from memory or disk.", func(s *StatsSnapshot) int64 { return s.CacheHits }},
+	{"zcache_group_loads_total", "Gets that missed the cache.", func(s *StatsSnapshot) int64 { return s.Loads }},
+	{"zcache_group_loads_deduped_total", "Loads after singleflight deduplication.", func(s *StatsSnapshot) int64 { return s.LoadsDeduped }},
+	{"zcache_group_peer_loads_total", "Loads served by peers.", func(s *StatsSnapshot) int64 { return s.PeerLoads }},
+	{"zcache_group_peer_errors_total", "Failed loads from peers.", func(s *StatsSnapshot) int64 { return s.PeerErrors }},
+	{"zcache_group_local_loads_total", "Loads served by the local getter.", func(s *StatsSnapshot) int64 { return s.LocalLoads }},
+	{"zcache_group_local_load_errors_total", "Failed loads from the local getter.", func(s *StatsSnapshot) int64 { return s.LocalLoadErrs }},
+	{"zcache_group_evictions_total", "Entries evicted from memory.", func(s *StatsSnapshot) int64 { return s.Evictions }},
+	{"zcache_group_negative_hits_total", "Gets answered as not found by the negative cache.", func(s *StatsSnapshot) int64 { return s.NegativeHits }},
+	{"zcache_group_stale_hits_total", "Gets served with a stale value past the soft TTL.", func(s *StatsSnapshot) int64 { return s.StaleHits }},
+	{"zcache_group_refreshes_total", "Background refreshes through the getter.", func(s *StatsSnapshot) int64 { return s.Refreshes }},
+	{"zcache_group_store_writes_total", "Successful writes and deletes to the backing store.", func(s *StatsSnapshot) int64 { return s.Writes }},
+	{"zcache_group_store_write_errors_total", "Failed writes and deletes to the backing store, including retries.", func(s *StatsSnapshot) int64 { return s.WriteErrors }},
+	{"zcache_group_store_writes_dropped_total", "Write-behind writes dropped after exhausting retries.", func(s *StatsSnapshot) int64 { return s.WritesDropped }},
+	{"zcache_group_hedges_total", "Hedged requests sent because a peer was slow.", func(s *StatsSnapshot) int64 { return s.Hedges }},
+	{"zcache_group_peer_retries_total", "Retried peer reads.", func(s *StatsSnapshot) int64 { return s.PeerRetries }},
+	{"zcache_group_read_repairs_total", "Stale replicas repaired during reads.", func(s *StatsSnapshot) int64 { return s.ReadRepairs }},
+}
+
+var groupGauges = []struct {
+	name string
+	help string
+	get  func(s *StatsSnapshot) int64
+}{
+	{"zcache_group_bytes", "Bytes of entries in memory.", func(s *StatsSnapshot) int64 { return s.Bytes }},
+	{"zcache_group_items", "Number of entries in memory.", func(s *StatsSnapshot) int64 { return s.Items }},
+	{"zcache_group_pending_writes", "Write-behind keys waiting to be written to the backing store.", func(s *StatsSnapshot) int64 { return s.PendingWrites }},
+}
+
+func collectGroups(w *metrics.Writer) {
+	names := GetGroupList()
+	sort.Strings(names)
+	stats := make([]StatsSnapshot, 0, len(names))
+	for _, name := range names {
+		if g := GetGroup(name); g != nil {
+			stats = append(stats, g.GetStats())
+		}
+	}
+	for _, c := range groupCounters {
+		w.Header(c.name, "counter", c.help)
+		for i := range stats {
+			w.Sample(c.name, float64(c.get(&stats[i])), "group", stats[i].Name)
+		}
+	}
+	for _, c := range groupGauges {
+		w.Header(c.name, "gauge", c.help)
+		for i := range stats {
+			w.Sample(c.name, float64(c.get(&stats[i])), "group", stats[i].Name)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package metrics 以Prometheus文本格式输出的指标
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets 默认的直方图桶，单位为秒
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector 能够将自己以文本格式写出的指标
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// CollectorFunc 接口型函数，用于在抓取时才计算的指标
+type CollectorFunc func(w *Writer)
+
+func (f CollectorFunc) Collect(w *Writer) {
+	f(w)
+}
+
+// Registry 保存所有注册的指标
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry 创建一个空的Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register 注册一个指标，指标按照注册的顺序输出
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo 将所有指标以Prometheus文本格式写入w
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+	mw := &Writer{w: w}
+	for _, c := range collectors {
+		c.Collect(mw)
+	}
+	return mw.n, mw.err
+}
+
+// Writer 用于按照文本格式写出指标，记录第一个发生的错误
+type Writer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *Writer) printf(format string, a ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, a...)
+	w.n += int64(n)
+	w.err = err
+}
+
+// Header 写出指标的HELP与TYPE行
+func (w *Writer) Header(name string, typ string, help string) {
+	w.printf("# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help))
+	w.printf("# TYPE %s %s\n", name, typ)
+}
+
+// Sample 写出一个样本，labels为交替出现的标签名与标签值
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), formatFloat(value))
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sb := strings.Builder{}
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(labels[i])
+		sb.WriteString(`="`)
+		sb.WriteString(strings.NewReplacer("\\", `\\`, "\n", `\n`, `"`, `\"`).Replace(labels[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 原子操作的float64
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// 带标签的指标的公共部分，children以标签值拼接成的字符串为键
+type vec[T any] struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	children   map[string]*T
+	values     map[string][]string
+	newChild   func() *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c
+	}
+	c := v.newChild()
+	v.children[key] = c
+	v.values[key] = append([]string(nil), values...)
+	return c
+}
+
+// 按照标签值排序后遍历所有子指标
+func (v *vec[T]) each(fn func(labels []string, c *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	children := make([]*T, len(keys))
+	labels := make([][]string, len(keys))
+	for i, k := range keys {
+		children[i] = v.children[k]
+		labels[i] = make([]string, 0, len(v.labelNames)*2)
+		for j, name := range v.labelNames {
+			labels[i] = append(labels[i], name, v.values[k][j])
+		}
+	}
+	v.mu.Unlock()
+	for i := range children {
+		fn(labels[i], children[i])
+	}
+}
+
+func newVec[T any](name string, help string, labelNames []string, newChild func() *T) vec[T] {
+	return vec[T]{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		children:   make(map[string]*T),
+		values:     make(map[string][]string),
+		newChild:   newChild,
+	}
+}
+
+// Counter 只增不减的计数器
+type Counter struct {
+	v atomicFloat
+}
+
+// Inc 计数器加1
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add 计数器加上v，v不能为负数
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.v.add(v)
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	vec[Counter]
+}
+
+// NewCounterVec 创建一个带标签的计数器
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, labelNames, func() *Counter { return &Counter{} })}
+}
+
+// WithLabelValues 获得对应标签值的计数器，不存在时创建
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.with(values)
+}
+
+func (v *CounterVec) Collect(w *Writer) {
+	w.Header(v.name, "counter", v.help)
+	v.each(func(labels []string, c *Counter) {
+		w.Sample(v.name, c.v.load(), labels...)
+	})
+}
+
+// Histogram 直方图，记录观测值落在各个桶中的数量
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64 // 每个桶中的数量，不累加
+	count       uint64
+	sum         atomicFloat
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	h.sum.add(v)
+	atomic.AddUint64(&h.count, 1)
+}
+
+func (h *Histogram) collect(w *Writer, name string, labels []string) {
+	var cumulative uint64
+	for i, upper := range h.upperBounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		w.Sample(name+"_bucket", float64(cumulative), append(labels, "le", formatFloat(upper))...)
+	}
+	count := atomic.LoadUint64(&h.count)
+	w.Sample(name+"_bucket", float64(count), append(labels, "le", "+Inf")...)
+	w.Sample(name+"_sum", h.sum.load(), labels...)
+	w.Sample(name+"_count", float64(count), labels...)
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	vec[Histogram]
+}
+
+// NewHistogramVec 创建一个带标签的直方图，buckets为各个桶的上界，需要递增
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{newVec(name, help, labelNames, func() *Histogram {
+		return &Histogram{upperBounds: buckets, counts: make([]uint64, len(buckets))}
+	})}
+}
+
+// WithLabelValues 获得对应标签值的直方图，不存在时创建
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.with(values)
+}
+
+func (v *HistogramVec) Collect(w *Writer) {
+	w.Header(v.name, "histogram", v.help)
+	v.each(func(labels []string, h *Histogram) {
+		h.collect(w, v.name, labels)
+	})
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounterVec("requests_total", "Total requests.", "method")
+	r.Register(c)
+	c.WithLabelValues("Get").Inc()
+	c.WithLabelValues("Get").Add(2)
+	c.WithLabelValues(`a"b`).Inc()
+	sb := strings.Builder{}
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	expect := `# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{method="Get"} 3
+requests_total{method="a\"b"} 1
+`
+	if sb.String() != expect {
+		t.Fatalf("expect\n%s\ngot\n%s", expect, sb.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+	r.Register(h)
+	h.WithLabelValues("Get").Observe(0.05)
+	h.WithLabelValues("Get").Observe(0.5)
+	h.WithLabelValues("Get").Observe(5)
+	sb := strings.Builder{}
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	expect := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="Get",le="0.1"} 1
+latency_seconds_bucket{method="Get",le="1"} 2
+latency_seconds_bucket{method="Get",le="+Inf"} 3
+latency_seconds_sum{method="Get"} 5.55
+latency_seconds_count{method="Get"} 3
+`
+	if sb.String() != expect {
+		t.Fatalf("expect\n%s\ngot\n%s", expect, sb.String())
+	}
+}
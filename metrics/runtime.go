@@ -0,0 +1,32 @@
+package metrics
+
+import "runtime"
+
+// RuntimeCollector 输出Go运行时的指标，包括协程数量、内存与GC的情况
+var RuntimeCollector = CollectorFunc(func(w *Writer) {
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+
+	w.Header("go_info", "gauge", "Information about the Go environment.")
+	w.Sample("go_info", 1, "version", runtime.Version())
+	w.Header("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	w.Sample("go_goroutines", float64(runtime.NumGoroutine()))
+	w.Header("go_sched_gomaxprocs_threads", "gauge", "Number of OS threads that can execute user-level Go code simultaneously.")
+	w.Sample("go_sched_gomaxprocs_threads", float64(runtime.GOMAXPROCS(0)))
+
+	w.Header("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	w.Sample("go_memstats_alloc_bytes", float64(ms.Alloc))
+	w.Header("go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.")
+	w.Sample("go_memstats_alloc_bytes_total", float64(ms.TotalAlloc))
+	w.Header("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.")
+	w.Sample("go_memstats_sys_bytes", float64(ms.Sys))
+	w.Header("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.")
+	w.Sample("go_memstats_heap_inuse_bytes", float64(ms.HeapInuse))
+	w.Header("go_memstats_heap_objects", "gauge", "Number of allocated objects.")
+	w.Sample("go_memstats_heap_objects", float64(ms.HeapObjects))
+
+	w.Header("go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	w.Sample("go_gc_cycles_total", float64(ms.NumGC))
+	w.Header("go_gc_pause_seconds_total", "counter", "Total time spent in GC stop-the-world pauses.")
+	w.Sample("go_gc_pause_seconds_total", float64(ms.PauseTotalNs)/1e9)
+})
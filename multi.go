@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+//多个键的批量操作，集群模式下按照负责的节点对键进行分组，每个节点只发送一次请求
+
+// 将键按照负责的节点进行分组，由自己负责或者远程节点不支持批量操作的键放入local中
+func (g *Group) splitByPeer(keys []string, usePeers bool) (local []string, byPeer map[MultiPeerGetter][]string) {
+	byPeer = make(map[MultiPeerGetter][]string)
+	for _, key := range keys {
+		if usePeers && g.peers != nil {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				if mp, ok := peer.(MultiPeerGetter); ok {
+					byPeer[mp] = append(byPeer[mp], key)
+					continue
+				}
+			}
+		}
+		local = append(local, key)
+	}
+	return
+}
+
+// GetMulti 批量获取数据，返回找到的键值对，不存在或加载失败的键不会出现在结果中，
+// 只有ctx被取消时才会返回错误
+func (g *Group) GetMulti(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	return g.getMulti(ctx, keys, true)
+}
+
+func (g *Group) getMulti(ctx context.Context, keys []string, usePeers bool) (map[string]ByteView, error) {
+	res := make(map[string]ByteView, len(keys))
+	missing := make([]string, 0)
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		g.Stats.Gets.Add(1)
+		if v, ok := g.mainCache.get(key); ok {
+			g.Stats.CacheHits.Add(1)
+			g.maybeRefresh(key, v)
+			res[key] = v
+		} else if v, ok := g.getFromDisk(key); ok {
+			g.Stats.CacheHits.Add(1)
+			res[key] = v
+		} else if g.negativeHas(key) {
+			g.Stats.NegativeHits.Add(1)
+		} else {
+			g.Stats.Loads.Add(1)
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+	local, byPeer := g.splitByPeer(missing, usePeers)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	loadOne := func(key string, usePeers bool) {
+		defer wg.Done()
+		if v, err := g.load(ctx, key, usePeers); err == nil {
+			mu.Lock()
+			res[key] = v
+			mu.Unlock()
+		}
+	}
+	for peer, ks := range byPeer {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := g.getMultiFromPeer(ctx, peer, ks)
+			if err != nil {
+				// 远程节点失败时逐个加载，由代替它的节点协调加载
+				g.Stats.PeerErrors.Add(int64(len(ks)))
+				log.Println("[GeeCache] Failed to get multi from peer", err)
+				for _, key := range ks {
+					wg.Add(1)
+					go loadOne(key, true)
+				}
+				return
+			}
+			g.Stats.PeerLoads.Add(int64(len(found)))
+			mu.Lock()
+			for k, v := range found {
+				res[k] = v
+			}
+			mu.Unlock()
+		}()
+	}
+	for _, key := range local {
+		wg.Add(1)
+		go loadOne(key, usePeers)
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	return res, ctx.Err()
+}
+
+func (g *Group) getMultiFromPeer(ctx context.Context, peer MultiPeerGetter, keys []string) (map[string]ByteView, error) {
+	out := &cachepb.MultiGetResponse{}
+	err := peer.GetMulti(ctx, &cachepb.MultiGetRequest{Group: g.name, Keys: keys}, out)
+	if err != nil {
+		peerRequests.WithLabelValues(peerAddr(peer), "error").Inc()
+		return nil, err
+	}
+	peerRequests.WithLabelValues(peerAddr(peer), "success").Inc()
+	res := make(map[string]ByteView, len(out.Entries))
+	for _, e := range out.Entries {
+		// 保留负责节点上的版本，之后的读修复与反熵依靠版本判断新旧
+		res[e.Key] = ByteView{b: e.Value, v: e.Version}
+	}
+	return res, nil
+}
+
+// SetMulti 批量设置数据，集群模式下每个键被设置到负责它的节点上，返回第一个发生的错误，
+// keys与values的数量必须相同
+func (g *Group) SetMulti(ctx context.Context, keys []string, values []ByteView) error {
+	return g.setMulti(ctx, keys, values, true)
+}
+
+func (g *Group) setMulti(ctx context.Context, keys []string, values []ByteView, usePeers bool) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("%d keys but %d values", len(keys), len(values))
+	}
+	index := make(map[string]int, len(keys))
+	for i, key := range keys {
+		index[key] = i
+	}
+	local, byPeer := g.splitByPeer(keys, usePeers)
+	// 先写入数据源，写入失败的键不会更新缓存
+	var storeErr error
+	written := make([]string, 0, len(local))
+	localValues := make([]ByteView, 0, len(local))
+	for _, key := range local {
+		if err := g.storeSet(key, values[index[key]].b); err != nil {
+			if storeErr == nil {
+				storeErr = err
+			}
+			continue
+		}
+		// 与Set相同，每次写入分配一个新的版本，副本与反熵据此判断数据的新旧
+		value := values[index[key]]
+		if value.v == 0 {
+			value.v = newVersion()
+		}
+		g.loader.Forget(key)
+		written = append(written, key)
+		localValues = append(localValues, value)
+	}
+	g.SetList(written, localValues)
+	err := g.fanOut(byPeer, func(peer MultiPeerGetter, ks []string) error {
+		in := &cachepb.MultiSetRequest{Group: g.name, Entries: make([]*cachepb.KeyValue, len(ks))}
+		for i, key := range ks {
+			in.Entries[i] = &cachepb.KeyValue{Key: key, Value: values[index[key]].b}
+		}
+		err := peer.SetMulti(ctx, in, &cachepb.Response{})
+		if err == nil || !unavailable(err) {
+			return err
+		}
+		// 负责的节点不可用时由自己写入数据源，并为它保存提示
+		for _, key := range ks {
+			if serr := g.storeSet(key, values[index[key]].b); serr != nil {
+				return serr
+			}
+			if herr := g.handoff(peer, key, values[index[key]], err); herr != nil {
+				return herr
+			}
+		}
+		return nil
+	})
+	if storeErr != nil {
+		return storeErr
+	}
+	return err
+}
+
+// DeleteMulti 批量删除数据，返回被删除的键，集群模式下每个键在负责它的节点上删除
+func (g *Group) DeleteMulti(ctx context.Context, keys []string) ([]string, error) {
+	return g.deleteMulti(ctx, keys, true)
+}
+
+func (g *Group) deleteMulti(ctx context.Context, keys []string, usePeers bool) ([]string, error) {
+	local, byPeer := g.splitByPeer(keys, usePeers)
+	deleted := make([]string, 0)
+	var storeErr error
+	for _, key := range local {
+		ok, err := g.Delete(key)
+		if err != nil && storeErr == nil {
+			storeErr = err
+		}
+		if ok {
+			deleted = append(deleted, key)
+		}
+	}
+	mu := sync.Mutex{}
+	err := g.fanOut(byPeer, func(peer MultiPeerGetter, ks []string) error {
+		out := &cachepb.MultiDeleteResponse{}
+		if err := peer.DeleteMulti(ctx, &cachepb.MultiDeleteRequest{Group: g.name, Keys: ks}, out); err != nil {
+			return err
+		}
+		mu.Lock()
+		deleted = append(deleted, out.Deleted...)
+		mu.Unlock()
+		return nil
+	})
+	if storeErr != nil {
+		return deleted, storeErr
+	}
+	return deleted, err
+}
+
+// 并发地向每个节点发送请求，返回第一个发生的错误
+func (g *Group) fanOut(byPeer map[MultiPeerGetter][]string, fn func(peer MultiPeerGetter, keys []string) error) error {
+	wg := sync.WaitGroup{}
+	errs := make(chan error, len(byPeer))
+	for peer, ks := range byPeer {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(peer, ks); err != nil {
+				peerRequests.WithLabelValues(peerAddr(peer), "error").Inc()
+				errs <- err
+				return
+			}
+			peerRequests.WithLabelValues(peerAddr(peer), "success").Inc()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 负责所有以p开头的键的远程节点
+type fakePeer struct {
+	mu    sync.Mutex
+	calls int
+	data  map[string][]byte
+}
+
+func (f *fakePeer) Get(in *cachepb.GetRequest, out *cachepb.Response) error {
+	out.Value = f.data[in.Key]
+	return nil
+}
+
+func (f *fakePeer) GetMulti(_ context.Context, in *cachepb.MultiGetRequest, out *cachepb.MultiGetResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	for _, key := range in.Keys {
+		if v, ok := f.data[key]; ok {
+			out.Entries = append(out.Entries, &cachepb.KeyValue{Key: key, Value: v, Version: uint64(len(v))})
+		}
+	}
+	return nil
+}
+
+func (f *fakePeer) SetMulti(_ context.Context, in *cachepb.MultiSetRequest, _ *cachepb.Response) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	for _, e := range in.Entries {
+		f.data[e.Key] = e.Value
+	}
+	return nil
+}
+
+func (f *fakePeer) DeleteMulti(_ context.Context, in *cachepb.MultiDeleteRequest, out *cachepb.MultiDeleteResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	for _, key := range in.Keys {
+		if _, ok := f.data[key]; ok {
+			delete(f.data, key)
+			out.Deleted = append(out.Deleted, key)
+		}
+	}
+	return nil
+}
+
+type fakePicker struct {
+	peer *fakePeer
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if strings.HasPrefix(key, "p") {
+		return p.peer, true
+	}
+	return nil, false
+}
+
+func TestMulti(t *testing.T) {
+	peer := &fakePeer{data: map[string][]byte{"p1": []byte("peer1")}}
+	g := NewGroup("multi-test", 2048, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("origin-" + key), nil
+	}))
+	defer DeleteGroup(g.name)
+	g.RegisterPeers(&fakePicker{peer: peer})
+	g.Set("l1", ByteView{b: []byte("local1")})
+
+	res, err := g.GetMulti(context.Background(), []string{"l1", "l2", "p1", "p2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"l1": "local1", "l2": "origin-l2", "p1": "peer1"}
+	if len(res) != len(expect) {
+		t.Fatalf("expect %d results, got %v", len(expect), res)
+	}
+	for k, v := range expect {
+		if res[k].String() != v {
+			t.Fatalf("expect %s=%s, got %s", k, v, res[k].String())
+		}
+	}
+	if peer.calls != 1 {
+		t.Fatalf("expect 1 batched peer call, got %d", peer.calls)
+	}
+	// 远程节点返回的版本被保留
+	if v := res["p1"].v; v != uint64(len("peer1")) {
+		t.Fatalf("expect version of p1 to be kept, got %d", v)
+	}
+
+	err = g.SetMulti(context.Background(), []string{"l3", "p3"}, []ByteView{{b: []byte("v3")}, {b: []byte("pv3")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.mainCache.get("l3"); !ok || v.String() != "v3" || v.v == 0 {
+		t.Fatalf("set l3 locally failed")
+	}
+	// 键与值的数量不同时返回错误
+	if err := g.SetMulti(context.Background(), []string{"l4", "l5"}, []ByteView{{b: []byte("v4")}}); err == nil {
+		t.Fatal("expect error for mismatched keys and values")
+	}
+	if string(peer.data["p3"]) != "pv3" {
+		t.Fatalf("set p3 on peer failed")
+	}
+	deleted, err := g.DeleteMulti(context.Background(), []string{"l3", "p3", "none"})
+	if err != nil || len(deleted) != 2 {
+		t.Fatalf("expect 2 keys deleted, got %v %v", deleted, err)
+	}
+}
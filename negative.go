@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+//负缓存，记录getter返回不存在的键，在过期之前对这些键的请求直接返回ErrNotFound，防止缓存穿透
+
+// 开启负缓存但是未设置大小时使用的默认大小
+const defaultNegativeBytes = 64 * 1024
+
+// ErrNotFound 数据源中不存在对应的键，getter可以返回包装了该错误的错误来表示键不存在
+var ErrNotFound = errors.New("not found")
+
+// 为组开启负缓存，ttl为0时不开启
+func (g *Group) openNegative(ttl int64, negBytes int64) {
+	if ttl <= 0 {
+		return
+	}
+	g.negativeTTL = time.Duration(ttl) * time.Second
+	g.negativeBytes = negBytes
+	if negBytes <= 0 {
+		negBytes = defaultNegativeBytes
+	}
+	g.negCache = &cache{cacheBytes: negBytes}
+}
+
+// 键是否在负缓存中且未过期
+func (g *Group) negativeHas(key string) bool {
+	if g.negCache == nil {
+		return false
+	}
+	_, ok := g.negCache.get(key)
+	return ok
+}
+
+// 将不存在的键加入负缓存
+func (g *Group) negativeAdd(key string) {
+	if g.negCache == nil {
+		return
+	}
+	g.negCache.add(key, ByteView{e: time.Now().Add(g.negativeTTL)})
+}
+
+// 键被写入时将其从负缓存中删除
+func (g *Group) negativeDelete(key string) {
+	if g.negCache != nil {
+		g.negCache.delete(key)
+	}
+}
+
+func (g *Group) negativeClear() {
+	if g.negCache != nil {
+		g.negCache.clear()
+	}
+}
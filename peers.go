@@ -1,11 +1,45 @@
 package cache
 
-import "cache/cachepb/cachepb"
+import (
+	"cache/cachepb/cachepb"
+	"context"
+)
 
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool) //用于根据传入的key选择相应的peergetter节点
 }
 
+// FallbackPicker 负责key的节点不可用时，选择代替它协调加载的节点，所有节点对同一个key会选出相同的节点，
+// 从而在节点故障期间整个集群对同一个key仍然只有一次加载，ok为false时表示由自己代替
+type FallbackPicker interface {
+	PickFallback(key string) (peer PeerGetter, ok bool)
+}
+
 type PeerGetter interface {
 	Get(in *cachepb.GetRequest, out *cachepb.Response) error //从对应的perrgetter中获取对应group中的对应key的值
 }
+
+// ContextPeerGetter 支持context的PeerGetter，ctx的截止时间会传递给远程节点
+type ContextPeerGetter interface {
+	PeerGetter
+	GetContext(ctx context.Context, in *cachepb.GetRequest, out *cachepb.Response) error
+}
+
+// ReplicaPicker 按照哈希环上的顺序选择保存key的n个节点，self表示自己是否在其中，peers不包括自己
+type ReplicaPicker interface {
+	PickReplicas(key string, n int) (peers []PeerGetter, self bool)
+}
+
+// VersionedSetter 支持带版本写入的远程节点，远程节点只在版本比已有数据新时写入，不会再转发给其他节点
+type VersionedSetter interface {
+	PeerGetter
+	SetVersioned(ctx context.Context, in *cachepb.SetRequest, out *cachepb.Response) error
+}
+
+// MultiPeerGetter 支持批量操作的远程节点，远程节点只处理自己负责的键，不会再转发给其他节点
+type MultiPeerGetter interface {
+	PeerGetter
+	GetMulti(ctx context.Context, in *cachepb.MultiGetRequest, out *cachepb.MultiGetResponse) error
+	SetMulti(ctx context.Context, in *cachepb.MultiSetRequest, out *cachepb.Response) error
+	DeleteMulti(ctx context.Context, in *cachepb.MultiDeleteRequest, out *cachepb.MultiDeleteResponse) error
+}
@@ -1,72 +1,484 @@
 package cache
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 快照文件的扩展名
+	snapshotExt = ".zsave"
+	// 定时持久化所使用的快照名称
+	defaultSnapshot = "persistence"
+	// PolicyLRU 最近最少使用淘汰策略，也是目前唯一支持的策略
+	PolicyLRU = "lru"
+	// 组未设置大小时使用的默认大小
+	defaultCacheBytes = 2048
 )
 
 type PersistenceType struct {
-	Key   string
-	Value []byte
+	Key    string
+	Value  []byte
+	Expire int64 // 过期时间的unix纳秒时间戳，0表示永不过期
+	// 软过期时间的unix纳秒时间戳，0表示没有软过期，加载之后已经软过期的数据会在下一次命中时刷新
+	SoftExpire int64
+	// 写入时的版本，0表示没有版本
+	Version uint64
 }
 
+// GroupInfo 组的元数据，组文件与持久化文件使用同一个结构来描述组，Num只在持久化文件中使用
 type GroupInfo struct {
-	Name string
-	Num  int
+	Name       string `yaml:"name"`
+	CacheBytes int64  `yaml:"cache-bytes"`
+	Policy     string `yaml:"policy"`
+	TTL        int64  `yaml:"ttl"` // 写入数据默认的过期时间，单位为秒，0表示永不过期
+	// 是否对该组进行持久化，未设置时使用服务器的persistence配置
+	Persistence *bool `yaml:"persistence,omitempty"`
+	// 该组持久化的间隔，单位为秒，0表示使用服务器的persistence-time配置
+	PersistenceTime int64 `yaml:"persistence-time,omitempty"`
+	// 磁盘缓存的最大空间，单位为字节，0表示不开启磁盘缓存
+	DiskBytes int64 `yaml:"disk-bytes,omitempty"`
+	// 磁盘缓存中单个段文件的最大大小，单位为字节，0表示使用默认值
+	DiskSegmentBytes int64 `yaml:"disk-segment-bytes,omitempty"`
+	// getter支持批量获取时，合并并发加载的时间窗口，单位为毫秒，0表示使用默认值
+	BatchWindow int64 `yaml:"batch-window,omitempty"`
+	// 一次批量获取的最大键数，0表示使用默认值
+	BatchSize int `yaml:"batch-size,omitempty"`
+	// 不存在的键在负缓存中保留的时间，单位为秒，0表示不开启负缓存
+	NegativeTTL int64 `yaml:"negative-ttl,omitempty"`
+	// 负缓存的最大空间，单位为字节，0表示使用默认值
+	NegativeBytes int64 `yaml:"negative-bytes,omitempty"`
+	// 从getter加载的数据的软过期时间，单位为秒，超过之后返回旧数据并在后台刷新，0表示不开启
+	SoftTTL int64 `yaml:"soft-ttl,omitempty"`
+	// 提前刷新的系数，越大越早刷新，0表示不开启
+	RefreshBeta float64 `yaml:"refresh-beta,omitempty"`
+	// getter实现了Setter或Deleter时写入数据源的模式，write-through或write-behind，默认为write-through
+	WriteMode string `yaml:"write-mode,omitempty"`
+	// write-behind模式下写队列写入数据源的间隔，单位为毫秒，0表示使用默认值
+	WriteInterval int64 `yaml:"write-interval,omitempty"`
+	// write-behind模式下一批写入的最大键数，0表示使用默认值
+	WriteBatch int `yaml:"write-batch,omitempty"`
+	// 远程节点超过该时间未返回时向代替节点发送对冲请求，单位为毫秒，0表示不开启
+	HedgeDelay int64 `yaml:"hedge-delay,omitempty"`
+	// 请求远程节点失败时的最大重试次数，0表示不重试
+	PeerRetries int `yaml:"peer-retries,omitempty"`
+	// 第一次重试之前等待的时间，单位为毫秒，之后每次翻倍并加上随机抖动，0表示使用默认值
+	RetryBackoff int64 `yaml:"retry-backoff,omitempty"`
+	// 每个键保存在哈希环上的几个节点上，大于1时可以按照一致性级别读写，0表示只保存在负责的节点上
+	Replicas int `yaml:"replicas,omitempty"`
+	// 一次共享加载的最长时间，单位为毫秒，超过之后getter与远程节点的请求被取消，0表示使用默认值
+	LoadTimeout int64 `yaml:"load-timeout,omitempty"`
+	Num         int   `yaml:"-"`
+}
+
+// SnapshotInfo 快照的信息
+type SnapshotInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// 定时持久化时缓存的组数据，只有到达组的持久化间隔时才会重新序列化
+type persistenceSection struct {
+	data  []byte
+	saved time.Time
+}
+
+var (
+	persistenceMu sync.Mutex // 保证同一时间只有一个快照操作在进行，同时保护sections
+	sections      = make(map[string]*persistenceSection)
+
+	dataDir             = "."              // 组文件、快照等数据文件所在的目录
+	persistenceDefault  = true             // 组默认是否开启持久化
+	persistenceInterval = 10 * time.Second // 组默认的持久化间隔
+)
+
+// SetDataDir 设置数据文件所在的目录，目录不存在时会自动创建
+func SetDataDir(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dataDir = dir
+	return nil
+}
+
+// SetPersistence 设置组默认是否开启持久化以及默认的持久化间隔，组文件中的配置会覆盖这里的默认值
+func SetPersistence(enabled bool, interval time.Duration) {
+	persistenceDefault = enabled
+	if interval > 0 {
+		persistenceInterval = interval
+	}
+}
+
+// 获得数据目录中对应文件的路径
+func dataPath(name string) string {
+	return filepath.Join(dataDir, name)
+}
+
+// 判断组信息所描述的组是否开启了持久化
+func (info *GroupInfo) persistent() bool {
+	if info.Persistence == nil {
+		return persistenceDefault
+	}
+	return *info.Persistence
+}
+
+// 获得组信息所描述的组的持久化间隔
+func (info *GroupInfo) saveInterval() time.Duration {
+	if info.PersistenceTime <= 0 {
+		return persistenceInterval
+	}
+	return time.Duration(info.PersistenceTime) * time.Second
 }
 
 // ByteToPersistence 将ByteView类型转换为Persistence类型
 func ByteToPersistence(key string, view *ByteView) PersistenceType {
-	return PersistenceType{Key: key, Value: cloneBytes(view.b)}
+	p := PersistenceType{Key: key, Value: cloneBytes(view.b), Version: view.v}
+	if !view.e.IsZero() {
+		p.Expire = view.e.UnixNano()
+	}
+	if !view.s.IsZero() {
+		p.SoftExpire = view.s.UnixNano()
+	}
+	return p
+}
+
+// PersistenceToByte 将Persistence类型转换为ByteView类型
+func PersistenceToByte(p *PersistenceType) ByteView {
+	v := ByteView{b: p.Value, v: p.Version}
+	if p.Expire != 0 {
+		v.e = time.Unix(0, p.Expire)
+	}
+	if p.SoftExpire != 0 {
+		v.s = time.Unix(0, p.SoftExpire)
+	}
+	return v
 }
 
-// SavePersistence 将数据进行保存
+// SavePersistence 将所有开启持久化的组进行保存，同时更新组文件，保证两者中的组信息一致
 func SavePersistence() {
 	fmt.Println("saving the persistence file...")
-	f, err := os.OpenFile("persistence.zsave", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
+	if _, err := SaveSnapshot(defaultSnapshot); err != nil {
 		panic(err)
 	}
-	defer f.Close()
-	for _, v := range GetGroupList() {
-		g := GetGroup(v)
-		if err := g.SaveGroup(f); err != nil {
-			panic(err)
+	fmt.Println("saving complete")
+}
+
+// SavePersistenceIfDue 只重新序列化到达持久化间隔的组，其余组沿用上一次序列化的数据，
+// 当有组被重新序列化时写入持久化文件
+func SavePersistenceIfDue() error {
+	persistenceMu.Lock()
+	defer persistenceMu.Unlock()
+	start := time.Now()
+	data, changed, err := collectSections(start, false)
+	if err != nil || !changed {
+		return err
+	}
+	defer observePersistence("save", start)
+	_, err = writeSnapshot(defaultSnapshot, data)
+	return err
+}
+
+// SaveSnapshot 将所有开启持久化的组保存为一个名为name的快照
+func SaveSnapshot(name string) (SnapshotInfo, error) {
+	if err := checkSnapshotName(name); err != nil {
+		return SnapshotInfo{}, err
+	}
+	persistenceMu.Lock()
+	defer persistenceMu.Unlock()
+	start := time.Now()
+	defer observePersistence("save", start)
+	data, _, err := collectSections(start, true)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return writeSnapshot(name, data)
+}
+
+// 将开启持久化的组序列化，force为false时只有到达组的持久化间隔才重新序列化，否则使用缓存的数据，
+// 返回所有组序列化后的数据以及是否有数据发生了变化，调用时需要持有persistenceMu
+func collectSections(now time.Time, force bool) ([][]byte, bool, error) {
+	changed := false
+	names := make(map[string]bool)
+	res := make([][]byte, 0)
+	for _, info := range GetGroupInfoList() {
+		if !info.persistent() {
+			continue
+		}
+		g := GetGroup(info.Name)
+		if g == nil {
+			continue
 		}
+		names[info.Name] = true
+		sec := sections[info.Name]
+		if force || sec == nil || now.Sub(sec.saved) >= info.saveInterval() {
+			buf := bytes.Buffer{}
+			if err := g.SaveGroup(&buf); err != nil {
+				return nil, false, err
+			}
+			sec = &persistenceSection{data: buf.Bytes(), saved: now}
+			sections[info.Name] = sec
+			changed = true
+		}
+		res = append(res, sec.data)
 	}
-	fmt.Println("saving complete")
+	// 删除已经不存在或者关闭了持久化的组
+	for name := range sections {
+		if !names[name] {
+			delete(sections, name)
+			changed = true
+		}
+	}
+	return res, changed, nil
+}
+
+// 将数据写入名为name的快照，先写入临时文件再重命名，避免保存中途失败时破坏原有的快照
+func writeSnapshot(name string, data [][]byte) (SnapshotInfo, error) {
+	path := dataPath(name + snapshotExt)
+	f, err := os.CreateTemp(dataDir, name+snapshotExt+".tmp*")
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	for _, d := range data {
+		if _, err := w.Write(d); err != nil {
+			f.Close()
+			return SnapshotInfo{}, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return SnapshotInfo{}, err
+	}
+	if err := f.Close(); err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return SnapshotInfo{}, err
+	}
+	UpdateGroupInfo()
+	stat, err := os.Stat(path)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return SnapshotInfo{Name: name, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// ListSnapshots 获得所有可用的快照，按照修改时间从新到旧排序
+func ListSnapshots() ([]SnapshotInfo, error) {
+	paths, err := filepath.Glob(dataPath("*" + snapshotExt))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]SnapshotInfo, 0, len(paths))
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		res = append(res, SnapshotInfo{
+			Name:    strings.TrimSuffix(filepath.Base(path), snapshotExt),
+			Size:    stat.Size(),
+			ModTime: stat.ModTime(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ModTime.After(res[j].ModTime)
+	})
+	return res, nil
+}
+
+// RestoreSnapshot 将快照恢复到正在运行的服务中，group为空时恢复快照中的所有组，
+// 否则只恢复对应的组，被恢复的组中原有的数据会被清空
+func RestoreSnapshot(name string, group string) error {
+	if err := checkSnapshotName(name); err != nil {
+		return err
+	}
+	persistenceMu.Lock()
+	defer persistenceMu.Unlock()
+	defer observePersistence("restore", time.Now())
+	f, err := os.Open(dataPath(name + snapshotExt))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := loadPersistence(f, loadOptions{group: group, replace: true})
+	if err != nil {
+		return err
+	}
+	if group != "" && n == 0 {
+		return fmt.Errorf("no group %s in snapshot %s", group, name)
+	}
+	return nil
+}
+
+// ExportGroup 将组以JSON Lines的格式导出到w中，第一行为组的元数据，之后每行为一个键值对
+func ExportGroup(group string, w io.Writer) error {
+	g := GetGroup(group)
+	if g == nil {
+		return fmt.Errorf("no such group: %s", group)
+	}
+	return g.SaveGroup(w)
+}
+
+// 将组中filter返回true的键以与ExportGroup相同的格式导出到w中
+func exportGroupFiltered(group string, w io.Writer, filter func(key string) bool) error {
+	g := GetGroup(group)
+	if g == nil {
+		return fmt.Errorf("no such group: %s", group)
+	}
+	info := g.Info()
+	return g.mainCache.saveCache(w, &info, filter)
+}
+
+// ImportGroup 导入通过ExportGroup导出的数据，group不为空时导入到该组中，否则导入到数据中记录的组中，
+// 导入的数据会与组中原有的数据合并
+func ImportGroup(r io.Reader, group string) error {
+	_, err := loadPersistence(r, loadOptions{rename: group})
+	return err
+}
+
+// 检查快照名称是否合法，快照名称不能包含路径
+func checkSnapshotName(name string) error {
+	return checkFileName("snapshot", name)
+}
+
+// 检查组名是否可以作为数据目录中的文件名，避免磁盘缓存等目录指向数据目录之外
+func checkGroupName(name string) error {
+	return checkFileName("group", name)
 }
 
-// LoadPersistence 加载持久化文件
-func LoadPersistence() {
+// 检查name是否为数据目录中的一个普通文件名，不能为空、包含路径或者以.开头
+func checkFileName(kind, name string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid %s name: %q", kind, name)
+	}
+	return nil
+}
+
+// LoadPersistence 加载持久化文件，已经存在的组以组文件中的配置为准，不存在的组使用持久化文件中的元数据创建，
+// 关闭了持久化的组不会被加载，返回是否有组从持久化文件中加载
+func LoadPersistence() bool {
 	fmt.Println("loading persistence file")
-	f, err := os.OpenFile("persistence.zsave", os.O_RDWR, 0644)
+	f, err := os.Open(dataPath(defaultSnapshot + snapshotExt))
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	defer f.Close()
+	defer observePersistence("load", time.Now())
+	n, err := loadPersistence(f, loadOptions{persistentOnly: true, preferDisk: true, skipDeleted: true})
 	if err != nil {
 		fmt.Println(err)
-		return
 	}
+	fmt.Println("load persistence file complete")
+	return n > 0
+}
+
+// 导入持久化数据时的选项
+type loadOptions struct {
+	group   string // 只导入该组的数据，为空时导入所有组
+	rename  string // 将数据导入到该组中，为空时使用数据中记录的组名
+	replace bool   // 导入前清空组中原有的数据
+	// 只导入开启了持久化的组
+	persistentOnly bool
+	// 跳过磁盘缓存中已经存在的键
+	preferDisk bool
+	// 不重新创建组目录中已经删除的组
+	skipDeleted bool
+	// 不为nil时记录导入的组
+	seen map[string]bool
+}
+
+// 从r中读取持久化数据并导入到对应的组中，返回导入的组的数量
+func loadPersistence(r io.Reader, opts loadOptions) (int, error) {
+	d := json.NewDecoder(r)
+	now := time.Now()
+	n := 0
 	for {
-		d := json.NewDecoder(f)
 		info := GroupInfo{}
 		if err := d.Decode(&info); err != nil {
-			fmt.Println(err)
-			break
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
 		}
-		keys := make([]string, info.Num)
-		values := make([]ByteView, info.Num)
-		for i := range info.Num {
+		keys := make([]string, 0, info.Num)
+		values := make([]ByteView, 0, info.Num)
+		for range info.Num {
 			e := PersistenceType{} // 每次创建新的实例
 			if err := d.Decode(&e); err != nil {
-				fmt.Println(err)
-				break
+				return n, err
+			}
+			v := PersistenceToByte(&e)
+			if v.expired(now) {
+				continue
 			}
-			keys[i] = e.Key
-			values[i] = ByteView{b: e.Value}
+			keys = append(keys, e.Key)
+			values = append(values, v)
+		}
+		if opts.group != "" && opts.group != info.Name {
+			continue
+		}
+		if opts.rename != "" {
+			info.Name = opts.rename
+		}
+		g := GetGroup(info.Name)
+		if opts.persistentOnly {
+			cur := info
+			if g != nil {
+				cur = g.Info()
+			}
+			if !cur.persistent() {
+				continue
+			}
+		}
+		if g == nil && opts.skipDeleted && catalogDeleted(info.Name) {
+			continue
+		}
+		if g == nil {
+			if err := checkGroupName(info.Name); err != nil {
+				return n, err
+			}
+			if info.CacheBytes == 0 {
+				info.CacheBytes = defaultCacheBytes
+			}
+			g = NewGroupWithInfo(info, nil)
+		} else if cur := g.Info(); info.CacheBytes != 0 && (cur.CacheBytes != info.CacheBytes || cur.TTL != info.TTL) {
+			log.Printf("[Zcache] group %s in persistence file differs from the current config, use the current config", info.Name)
+		}
+		if opts.replace {
+			g.clear()
+		}
+		if opts.preferDisk {
+			// 启动时磁盘缓存中的数据是在快照之后被淘汰到磁盘上的，比快照中的数据更新
+			i := 0
+			for j := range keys {
+				if !g.diskHas(keys[j]) {
+					keys[i], values[i] = keys[j], values[j]
+					i++
+				}
+			}
+			keys, values = keys[:i], values[:i]
 		}
-		g := NewGroup(info.Name, 2048, nil)
 		g.SetList(keys, values)
+		if opts.seen != nil {
+			opts.seen[info.Name] = true
+		}
+		n++
 	}
-
-	fmt.Println("load persistence file complete")
 }
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPersistenceKeepsInfoAndOrder(t *testing.T) {
+	g := NewGroupWithInfo(GroupInfo{Name: "persistence-test", CacheBytes: 4096, TTL: 60}, nil)
+	defer DeleteGroup(g.name)
+	g.Set("k1", ByteView{b: []byte("v1")})
+	g.Set("k2", ByteView{b: []byte("v2")})
+	g.Set("k3", ByteView{b: []byte("v3")})
+	g.Set("gone", ByteView{b: []byte("v"), e: time.Now().Add(-time.Second)})
+	// 访问k1使其成为最近使用的数据
+	if _, err := g.Get("k1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	if err := g.SaveGroup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	DeleteGroup(g.name)
+
+	if _, err := loadPersistence(&buf, loadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	loaded := GetGroup(g.name)
+	if loaded == nil {
+		t.Fatalf("group %s not restored", g.name)
+	}
+	if info := loaded.Info(); info.CacheBytes != 4096 || info.TTL != 60 || info.Policy != PolicyLRU {
+		t.Fatalf("group info not restored, got %+v", info)
+	}
+	var keys []string
+	for _, e := range loaded.mainCache.lru.GetKVList() {
+		keys = append(keys, e.Key)
+		if e.Value.(ByteView).Expire().IsZero() {
+			t.Fatalf("expire of %s not restored", e.Key)
+		}
+	}
+	if expect := []string{"k1", "k3", "k2"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expect recency order %v, got %v", expect, keys)
+	}
+}
+
+func TestPersistenceKeepsSoftExpireAndVersion(t *testing.T) {
+	g := NewGroupWithInfo(GroupInfo{Name: "soft-persistence-test", CacheBytes: 2048, SoftTTL: 60}, nil)
+	defer DeleteGroup(g.name)
+	soft := time.Now().Add(time.Minute)
+	g.mainCache.add("k", ByteView{b: []byte("v"), s: soft, v: 7})
+	buf := bytes.Buffer{}
+	if err := g.SaveGroup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	DeleteGroup(g.name)
+	if _, err := loadPersistence(&buf, loadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := GetGroup(g.name).mainCache.get("k")
+	if !ok || !v.s.Equal(time.Unix(0, soft.UnixNano())) {
+		t.Fatalf("soft expire not restored, got %v", v.s)
+	}
+	if v.v != 7 {
+		t.Fatalf("version not restored, got %d", v.v)
+	}
+}
+
+func TestExportImportGroup(t *testing.T) {
+	g := NewGroup("export-test", 2048, nil)
+	defer DeleteGroup(g.name)
+	g.Set("k", ByteView{b: []byte("v")})
+	buf := bytes.Buffer{}
+	if err := ExportGroup(g.name, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportGroup(&buf, "import-test"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteGroup("import-test")
+	imported := GetGroup("import-test")
+	if imported == nil {
+		t.Fatalf("group import-test not created")
+	}
+	if v, err := imported.Get("k"); err != nil || v.String() != "v" {
+		t.Fatalf("import k=v failed, got %v %v", v, err)
+	}
+}
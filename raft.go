@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"bytes"
+	"cache/raft"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+//开启raft之后，组目录与集群中的节点列表通过raft复制，组的创建、修改与删除以及节点列表的修改都作为日志提交，
+//所有节点按照相同的顺序应用，目录中每个组的版本为对应日志的位置，目录同时记录已经应用的位置，重启之后不会重复应用。
+//这些管理操作只能由leader处理，其他节点收到时会转发给leader，
+//带有consistent参数的GetCatalog同样由leader在确认自己仍然是leader之后返回
+
+// 等待管理操作被提交的最长时间
+const raftApplyTimeout = 5 * time.Second
+
+const (
+	raftOpCatalog = "catalog"
+	raftOpPeers   = "peers"
+)
+
+// 需要由leader处理的请求
+var leaderMethods = map[string]bool{
+	"CreateGroup":  true,
+	"UpdateGroup":  true,
+	"DeleteGroup":  true,
+	"SetPeers":     true,
+	"ApplyCatalog": true,
+}
+
+// 通过raft提交的命令
+type raftCommand struct {
+	Op    string
+	Entry CatalogEntry `json:",omitempty"`
+	Peers []string     `json:",omitempty"`
+}
+
+// StartRaft 以voters中的节点为成员启动raft，voters为空时只有自己，状态保存在dir中
+func (p *HTTPPool) StartRaft(dir string, voters []string) error {
+	if len(voters) == 0 {
+		voters = []string{p.self}
+	}
+	p.raftGetters = make(map[string]*HttpGetter, len(voters))
+	for _, peer := range voters {
+		p.raftGetters[peer] = &HttpGetter{BaseURL: peer, Client: p.client, isPeer: true}
+	}
+	node, err := raft.NewNode(raft.Config{
+		ID:        p.self,
+		Peers:     voters,
+		Dir:       dir,
+		Transport: p,
+		Apply:     p.applyRaft,
+		Logf:      p.Log,
+	})
+	if err != nil {
+		return err
+	}
+	p.raft = node
+	return nil
+}
+
+// RaftStatus 获得raft节点的状态，未开启raft时返回false
+func (p *HTTPPool) RaftStatus() (raft.Status, bool) {
+	if p.raft == nil {
+		return raft.Status{}, false
+	}
+	return p.raft.Status(), true
+}
+
+// 应用被提交的命令
+func (p *HTTPPool) applyRaft(index uint64, command []byte) {
+	c := raftCommand{}
+	if err := json.Unmarshal(command, &c); err != nil {
+		p.Log("invalid raft command at %d: %v", index, err)
+		return
+	}
+	switch c.Op {
+	case raftOpCatalog:
+		applyCatalogAt(index, c.Entry)
+	case raftOpPeers:
+		p.Set(c.Peers...)
+		p.Log("peers set to %v at %d", c.Peers, index)
+	default:
+		p.Log("unknown raft command %q at %d", c.Op, index)
+	}
+}
+
+// 提交一条命令并等待它被应用
+func (p *HTTPPool) propose(ctx context.Context, c raftCommand) error {
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, raftApplyTimeout)
+	defer cancel()
+	_, err = p.raft.Apply(ctx, data)
+	return err
+}
+
+// 修改组目录，开启raft时通过raft提交修改，否则直接修改并发送给其他节点
+func (p *HTTPPool) changeCatalog(w http.ResponseWriter, r *http.Request, info GroupInfo, deleted bool) {
+	if p.raft == nil {
+		p.broadcastCatalog(catalogSet(p.self, info, deleted))
+		return
+	}
+	c := raftCommand{Op: raftOpCatalog, Entry: CatalogEntry{Info: info, Origin: p.self, Deleted: deleted}}
+	if err := p.propose(r.Context(), c); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// 开启raft时合并其他节点发送的目录，比本地版本更高并且内容不同的项逐个通过raft提交，不会直接修改本地的目录
+func (p *HTTPPool) proposeCatalog(ctx context.Context, remote Catalog) error {
+	local := GetCatalog()
+	for name, e := range remote.Groups {
+		if l, ok := local.Groups[name]; ok && (!e.newer(l) || l.Deleted == e.Deleted && reflect.DeepEqual(l.Info, e.Info)) {
+			continue
+		}
+		c := raftCommand{Op: raftOpCatalog, Entry: CatalogEntry{Info: e.Info, Origin: e.Origin, Deleted: e.Deleted}}
+		if err := p.propose(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 开启raft时将需要由leader处理的请求转发给leader，返回请求是否已经被处理
+func (p *HTTPPool) forwardToLeader(w http.ResponseWriter, r *http.Request, method string, body []byte) bool {
+	if p.raft == nil || p.raft.IsLeader() {
+		return false
+	}
+	if !leaderMethods[method] && !(method == "GetCatalog" && r.URL.Query().Get("consistent") != "") {
+		return false
+	}
+	leader := p.raft.Leader()
+	// 转发过来的请求不再次转发，避免leader变化期间在节点之间循环
+	if leader == "" || r.Header.Get(forwardedHeader) != "" {
+		http.Error(w, "no leader", http.StatusServiceUnavailable)
+		return true
+	}
+	getter := p.raftGetters[leader]
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, leader+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	req.Header.Set(forwardedHeader, "1")
+	res, err := getter.HTTPClient().Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("forward to leader %s: %v", leader, err), http.StatusBadGateway)
+		return true
+	}
+	defer res.Body.Close()
+	w.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+	w.WriteHeader(res.StatusCode)
+	_, _ = io.Copy(w, res.Body)
+	return true
+}
+
+// RequestVote 实现raft.Transport
+func (p *HTTPPool) RequestVote(ctx context.Context, peer string, args *raft.VoteArgs) (*raft.VoteReply, error) {
+	reply := &raft.VoteReply{}
+	return reply, p.raftCall(ctx, peer, "RaftVote", args, reply)
+}
+
+// AppendEntries 实现raft.Transport
+func (p *HTTPPool) AppendEntries(ctx context.Context, peer string, args *raft.AppendArgs) (*raft.AppendReply, error) {
+	reply := &raft.AppendReply{}
+	return reply, p.raftCall(ctx, peer, "RaftAppend", args, reply)
+}
+
+func (p *HTTPPool) raftCall(ctx context.Context, peer string, method string, in any, out any) error {
+	getter := p.raftGetters[peer]
+	if getter == nil {
+		return fmt.Errorf("unknown raft peer %s", peer)
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return getter.doJSON(req, out)
+}
+
+var _ raft.Transport = (*HTTPPool)(nil)
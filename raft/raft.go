@@ -0,0 +1,813 @@
+// Package raft 一个简单的raft实现，用于在节点之间复制少量的元数据
+package raft
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+节点之间通过Transport通信，任期与投票在修改之后写入Dir目录中的raft.json，日志按行追加到raft.log中，
+被截断的日志不会从文件中删除，加载时后写入的项会覆盖之前同一位置以及之后的项。
+状态没有写入磁盘时节点不会投票或者接受日志，leader也不会追加新的日志。
+leader在一个选举超时时间内没有收到多数节点的响应时会退位，因此被分区的少数派不会继续接受修改。
+集群的成员在启动时确定，不支持通过raft修改成员
+*/
+
+const (
+	stateFileName            = "raft.json"
+	logFileName              = "raft.log"
+	defaultHeartbeatInterval = 100 * time.Millisecond
+	defaultElectionTimeout   = time.Second
+)
+
+var (
+	ErrNotLeader      = errors.New("raft: not leader")
+	ErrLeadershipLost = errors.New("raft: leadership lost before the entry was committed")
+	ErrStopped        = errors.New("raft: node stopped")
+)
+
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+// Entry 日志中的一项，Command为空时表示不需要应用的空操作
+type Entry struct {
+	Index   uint64
+	Term    uint64
+	Command []byte
+}
+
+type VoteArgs struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type VoteReply struct {
+	Term    uint64
+	Granted bool
+}
+
+type AppendArgs struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+type AppendReply struct {
+	Term    uint64
+	Success bool
+	// 失败时leader下一次应该从哪一项开始发送
+	ConflictIndex uint64
+	// 日志没有冲突但是无法写入磁盘，leader停止发送，在下一次心跳时重试
+	PersistFailed bool
+}
+
+// Transport 节点之间的通信方式
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, args *VoteArgs) (*VoteReply, error)
+	AppendEntries(ctx context.Context, peer string, args *AppendArgs) (*AppendReply, error)
+}
+
+// Config 节点的配置
+type Config struct {
+	ID                string        // 节点的标识
+	Peers             []string      // 集群中的所有节点，包括自己
+	Dir               string        // 保存状态的目录，为空时不保存
+	HeartbeatInterval time.Duration // leader发送心跳的间隔
+	ElectionTimeout   time.Duration // 选举超时时间，实际的超时时间在[ElectionTimeout, 2*ElectionTimeout)之间随机
+	Transport         Transport
+	// 日志被提交之后按照顺序调用，同一时间只会有一次调用
+	Apply func(index uint64, command []byte)
+	Logf  func(format string, v ...interface{})
+}
+
+// Status 节点当前的状态
+type Status struct {
+	ID          string
+	State       string
+	Term        uint64
+	Leader      string
+	CommitIndex uint64
+	LastApplied uint64
+	LastIndex   uint64
+	Peers       []string
+}
+
+type waiter struct {
+	term uint64
+	ch   chan error
+}
+
+// Node raft节点
+type Node struct {
+	cfg Config
+
+	mu          sync.Mutex
+	state       State
+	term        uint64
+	votedFor    string
+	log         []Entry // log[0]是一个空的哨兵，log[i].Index == i
+	commitIndex uint64
+	lastApplied uint64
+	leader      string
+	votes       int
+
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact map[string]time.Time // leader最后一次收到节点响应的时间
+	inflight    map[string]bool      // 是否有正在向节点发送日志的goroutine
+
+	electionDeadline time.Time
+	lastHeartbeat    time.Time
+	waiters          map[uint64]waiter // 等待日志被应用的Apply调用
+
+	saved      persistentState // 已经写入磁盘的任期与投票
+	savedIndex uint64          // 已经写入日志文件的最后一项
+	rewriteLog bool            // 日志文件需要重写，写入失败或者加载到不完整的日志时设置
+
+	applyCh chan struct{}
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// 保存在磁盘上的任期与投票
+type persistentState struct {
+	Term     uint64
+	VotedFor string
+}
+
+// NewNode 创建并启动一个节点，Dir中存在之前保存的状态时从中恢复
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.ID == "" || cfg.Transport == nil {
+		return nil, errors.New("raft: ID and Transport are required")
+	}
+	if !slices.Contains(cfg.Peers, cfg.ID) {
+		cfg.Peers = append(slices.Clone(cfg.Peers), cfg.ID)
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = defaultElectionTimeout
+	}
+	if cfg.Logf == nil {
+		cfg.Logf = log.Printf
+	}
+	n := &Node{
+		cfg:         cfg,
+		log:         []Entry{{}},
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		lastContact: make(map[string]time.Time),
+		inflight:    make(map[string]bool),
+		waiters:     make(map[uint64]waiter),
+		applyCh:     make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+	n.resetElection()
+	n.wg.Add(2)
+	go n.run()
+	go n.runApplier()
+	return n, nil
+}
+
+// Stop 停止节点，正在等待的Apply调用返回ErrStopped
+func (n *Node) Stop() {
+	close(n.stopCh)
+	n.wg.Wait()
+}
+
+// Apply 提交一条命令，只能在leader上调用，命令被应用之后返回它在日志中的位置
+func (n *Node) Apply(ctx context.Context, command []byte) (uint64, error) {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return 0, ErrNotLeader
+	}
+	index, err := n.appendEntry(command)
+	if err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+	ch := make(chan error, 1)
+	n.waiters[index] = waiter{term: n.term, ch: ch}
+	n.broadcast()
+	n.mu.Unlock()
+	select {
+	case err := <-ch:
+		return index, err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, index)
+		n.mu.Unlock()
+		return index, ctx.Err()
+	case <-n.stopCh:
+		return index, ErrStopped
+	}
+}
+
+// Barrier 提交一个空操作并等待它被应用，返回之后leader上的状态包含了之前所有被提交的修改，用于一致性读
+func (n *Node) Barrier(ctx context.Context) error {
+	_, err := n.Apply(ctx, nil)
+	return err
+}
+
+// IsLeader 节点是否为leader
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == Leader
+}
+
+// Leader 获得当前已知的leader，未知时返回空字符串
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Status 获得节点当前的状态
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:          n.cfg.ID,
+		State:       n.state.String(),
+		Term:        n.term,
+		Leader:      n.leader,
+		CommitIndex: n.commitIndex,
+		LastApplied: n.lastApplied,
+		LastIndex:   n.lastIndex(),
+		Peers:       slices.Clone(n.cfg.Peers),
+	}
+}
+
+// HandleVote 处理其他节点的投票请求
+func (n *Node) HandleVote(args *VoteArgs) *VoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term > n.term {
+		n.becomeFollower(args.Term, "")
+	}
+	reply := &VoteReply{Term: n.term}
+	if args.Term < n.term {
+		return reply
+	}
+	lastTerm := n.log[n.lastIndex()].Term
+	upToDate := args.LastLogTerm > lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.Candidate) && upToDate {
+		n.votedFor = args.Candidate
+		// 投票写入磁盘之后才能同意，否则重启之后可能在同一个任期再次投票
+		if err := n.persist(); err != nil {
+			n.cfg.Logf("[raft %s] refuse to vote: %v", n.cfg.ID, err)
+			return reply
+		}
+		n.resetElection()
+		reply.Granted = true
+	}
+	return reply
+}
+
+// HandleAppend 处理leader发送的日志与心跳
+func (n *Node) HandleAppend(args *AppendArgs) *AppendReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term < n.term {
+		return &AppendReply{Term: n.term}
+	}
+	if args.Term > n.term || n.state != Follower {
+		n.becomeFollower(args.Term, args.Leader)
+	}
+	n.leader = args.Leader
+	n.resetElection()
+	reply := &AppendReply{Term: n.term}
+	if args.PrevLogIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return reply
+	}
+	if term := n.log[args.PrevLogIndex].Term; term != args.PrevLogTerm {
+		// 跳过冲突的整个任期
+		i := args.PrevLogIndex
+		for i > 1 && n.log[i-1].Term == term {
+			i--
+		}
+		reply.ConflictIndex = i
+		return reply
+	}
+	old, oldSaved := n.log, n.savedIndex
+	for i, e := range args.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.log[e.Index].Term == e.Term {
+				continue
+			}
+			n.truncate(e.Index)
+		}
+		// 在副本上追加，写入磁盘失败时恢复原来的日志
+		n.log = append(slices.Clone(n.log), args.Entries[i:]...)
+		break
+	}
+	// 日志写入磁盘之后才能响应成功，否则leader可能提交只存在于内存中的日志
+	if err := n.persist(); err != nil {
+		n.cfg.Logf("[raft %s] refuse to append: %v", n.cfg.ID, err)
+		// 磁盘上的日志文件可能已经部分改变，下一次写入时按照内存中的日志重写
+		n.log, n.savedIndex, n.rewriteLog = old, oldSaved, true
+		reply.PersistFailed = true
+		return reply
+	}
+	if last := args.PrevLogIndex + uint64(len(args.Entries)); args.LeaderCommit > n.commitIndex {
+		n.commitIndex = min(args.LeaderCommit, last)
+		n.signalApply()
+	}
+	reply.Success = true
+	return reply
+}
+
+//---------------------------------------------------------------------------------------------------------------------
+
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+			n.tick()
+		}
+	}
+}
+
+func (n *Node) tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	if n.state == Leader {
+		if !n.hasQuorum(now) {
+			n.cfg.Logf("[raft %s] lost contact with the majority, step down", n.cfg.ID)
+			n.becomeFollower(n.term, "")
+			return
+		}
+		if now.Sub(n.lastHeartbeat) >= n.cfg.HeartbeatInterval {
+			n.broadcast()
+		}
+		return
+	}
+	if now.After(n.electionDeadline) {
+		n.startElection()
+	}
+}
+
+// 按照顺序应用被提交的日志
+func (n *Node) runApplier() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.applyCh:
+		}
+		for {
+			n.mu.Lock()
+			if n.lastApplied >= n.commitIndex {
+				n.mu.Unlock()
+				break
+			}
+			e := n.log[n.lastApplied+1]
+			n.mu.Unlock()
+			if len(e.Command) > 0 && n.cfg.Apply != nil {
+				n.cfg.Apply(e.Index, e.Command)
+			}
+			n.mu.Lock()
+			n.lastApplied = e.Index
+			if w, ok := n.waiters[e.Index]; ok {
+				delete(n.waiters, e.Index)
+				if w.term == e.Term {
+					w.ch <- nil
+				} else {
+					w.ch <- ErrLeadershipLost
+				}
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// 以下的方法都需要在持有锁的情况下调用
+
+func (n *Node) startElection() {
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.cfg.ID
+	n.leader = ""
+	n.votes = 1
+	n.resetElection()
+	if err := n.persist(); err != nil {
+		n.cfg.Logf("[raft %s] failed to start election: %v", n.cfg.ID, err)
+		n.state = Follower
+		return
+	}
+	n.cfg.Logf("[raft %s] start election for term %d", n.cfg.ID, n.term)
+	if n.votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	args := &VoteArgs{
+		Term:         n.term,
+		Candidate:    n.cfg.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.log[n.lastIndex()].Term,
+	}
+	for _, peer := range n.others() {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			defer cancel()
+			reply, err := n.cfg.Transport.RequestVote(ctx, peer, args)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.term {
+				n.becomeFollower(reply.Term, "")
+				return
+			}
+			if n.state != Candidate || n.term != args.Term || !reply.Granted {
+				return
+			}
+			n.votes++
+			if n.votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}()
+	}
+}
+
+func (n *Node) becomeLeader() {
+	n.cfg.Logf("[raft %s] became leader for term %d", n.cfg.ID, n.term)
+	n.state = Leader
+	n.leader = n.cfg.ID
+	now := time.Now()
+	for _, peer := range n.others() {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+		n.lastContact[peer] = now
+	}
+	// leader只能直接提交自己任期内的日志，通过空操作提交之前任期的日志
+	if _, err := n.appendEntry(nil); err != nil {
+		n.cfg.Logf("[raft %s] step down: %v", n.cfg.ID, err)
+		n.becomeFollower(n.term, "")
+		return
+	}
+	n.broadcast()
+}
+
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		// 失败时在下一次投票或者接受日志之前重新写入
+		if err := n.persist(); err != nil {
+			n.cfg.Logf("[raft %s] %v", n.cfg.ID, err)
+		}
+	}
+	n.state = Follower
+	n.leader = leader
+	n.resetElection()
+}
+
+// 追加一项日志，写入磁盘失败时移除该项并返回错误
+func (n *Node) appendEntry(command []byte) (uint64, error) {
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Command: command}
+	n.log = append(n.log, e)
+	if err := n.persist(); err != nil {
+		n.log = n.log[:e.Index]
+		return 0, err
+	}
+	n.advanceCommit()
+	return e.Index, nil
+}
+
+// 删除从index开始的日志，等待这些日志的Apply调用返回ErrLeadershipLost
+func (n *Node) truncate(index uint64) {
+	for i := index; i <= n.lastIndex(); i++ {
+		if w, ok := n.waiters[i]; ok {
+			delete(n.waiters, i)
+			w.ch <- ErrLeadershipLost
+		}
+	}
+	n.log = n.log[:index]
+	// 新的日志追加到文件之后会覆盖被截断的项
+	n.savedIndex = min(n.savedIndex, index-1)
+}
+
+// 向所有节点发送日志，已经有正在发送的goroutine的节点会在它结束之前继续发送
+func (n *Node) broadcast() {
+	n.lastHeartbeat = time.Now()
+	for _, peer := range n.others() {
+		if !n.inflight[peer] {
+			n.inflight[peer] = true
+			go n.replicate(peer)
+		}
+	}
+}
+
+// 向节点发送日志，直到节点追上leader或者请求失败
+func (n *Node) replicate(peer string) {
+	for {
+		n.mu.Lock()
+		if n.state != Leader {
+			n.inflight[peer] = false
+			n.mu.Unlock()
+			return
+		}
+		next := n.nextIndex[peer]
+		args := &AppendArgs{
+			Term:         n.term,
+			Leader:       n.cfg.ID,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  n.log[next-1].Term,
+			Entries:      slices.Clone(n.log[next:]),
+			LeaderCommit: n.commitIndex,
+		}
+		n.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+		reply, err := n.cfg.Transport.AppendEntries(ctx, peer, args)
+		cancel()
+		n.mu.Lock()
+		more := err == nil && n.handleAppendReply(peer, args, reply)
+		if !more {
+			n.inflight[peer] = false
+			n.mu.Unlock()
+			return
+		}
+		n.mu.Unlock()
+	}
+}
+
+// 处理节点的响应，返回是否需要立即继续发送
+func (n *Node) handleAppendReply(peer string, args *AppendArgs, reply *AppendReply) bool {
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term, "")
+		return false
+	}
+	if n.state != Leader || n.term != args.Term {
+		return false
+	}
+	n.lastContact[peer] = time.Now()
+	if reply.PersistFailed {
+		// 节点暂时无法写入磁盘，立即重新发送只会重复失败
+		return false
+	}
+	if !reply.Success {
+		n.nextIndex[peer] = max(1, min(reply.ConflictIndex, args.PrevLogIndex))
+		return true
+	}
+	if match := args.PrevLogIndex + uint64(len(args.Entries)); match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommit()
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// 提交被多数节点复制的当前任期的日志
+func (n *Node) advanceCommit() {
+	for i := n.lastIndex(); i > n.commitIndex && n.log[i].Term == n.term; i-- {
+		count := 1
+		for _, peer := range n.others() {
+			if n.matchIndex[peer] >= i {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = i
+			n.signalApply()
+			return
+		}
+	}
+}
+
+// 最近一个选举超时时间内是否收到了多数节点的响应
+func (n *Node) hasQuorum(now time.Time) bool {
+	count := 1
+	for _, peer := range n.others() {
+		if now.Sub(n.lastContact[peer]) < n.cfg.ElectionTimeout {
+			count++
+		}
+	}
+	return count >= n.quorum()
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) resetElection() {
+	d := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(d)
+}
+
+func (n *Node) others() []string {
+	res := make([]string, 0, len(n.cfg.Peers)-1)
+	for _, peer := range n.cfg.Peers {
+		if peer != n.cfg.ID {
+			res = append(res, peer)
+		}
+	}
+	return res
+}
+
+func (n *Node) quorum() int {
+	return len(n.cfg.Peers)/2 + 1
+}
+
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log) - 1)
+}
+
+// 将任期、投票与还未写入的日志写入磁盘，任期与投票没有变化时不会重写状态文件
+func (n *Node) persist() error {
+	if n.cfg.Dir == "" {
+		return nil
+	}
+	if err := n.persistLog(); err != nil {
+		return fmt.Errorf("raft: save log: %w", err)
+	}
+	state := persistentState{Term: n.term, VotedFor: n.votedFor}
+	if state.Term == n.saved.Term && state.VotedFor == n.saved.VotedFor {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(n.cfg.Dir, stateFileName), func(w *bufio.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		return fmt.Errorf("raft: save state: %w", err)
+	}
+	n.saved = state
+	return nil
+}
+
+// 将还未写入的日志追加到日志文件中，需要时重写整个文件
+func (n *Node) persistLog() error {
+	path := filepath.Join(n.cfg.Dir, logFileName)
+	if n.rewriteLog || n.savedIndex > n.lastIndex() {
+		if err := writeFile(path, func(w *bufio.Writer) error {
+			return encodeEntries(w, n.log[1:])
+		}); err != nil {
+			return err
+		}
+		n.rewriteLog, n.savedIndex = false, n.lastIndex()
+		return nil
+	}
+	if n.savedIndex == n.lastIndex() {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = encodeEntries(w, n.log[n.savedIndex+1:])
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// 文件末尾可能只写入了一部分，下一次重写整个文件
+		n.rewriteLog = true
+		return err
+	}
+	n.savedIndex = n.lastIndex()
+	return nil
+}
+
+func encodeEntries(w *bufio.Writer, entries []Entry) error {
+	e := json.NewEncoder(w)
+	for i := range entries {
+		if err := e.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 通过临时文件原子地替换path
+func writeFile(path string, write func(w *bufio.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (n *Node) load() error {
+	if n.cfg.Dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(n.cfg.Dir, stateFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		s := persistentState{}
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		n.term, n.votedFor, n.saved = s.Term, s.VotedFor, s
+	}
+	if err := n.loadLog(); err != nil {
+		return err
+	}
+	if n.rewriteLog {
+		return n.persist()
+	}
+	n.savedIndex = n.lastIndex()
+	return nil
+}
+
+// 按顺序读取日志文件，后写入的项截断之前同一位置以及之后的项
+func (n *Node) loadLog() error {
+	f, err := os.Open(filepath.Join(n.cfg.Dir, logFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Index == 0 || e.Index > n.lastIndex()+1 {
+			// 写入到一半的最后一行，之后的内容在下一次写入时重写
+			n.cfg.Logf("[raft %s] ignore broken log after index %d", n.cfg.ID, n.lastIndex())
+			n.rewriteLog = true
+			break
+		}
+		n.log = append(n.log[:e.Index], e)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,310 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 在内存中直接调用其他节点的Transport，可以断开节点之间的连接
+type memTransport struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	down  map[string]bool // 被断开的节点
+}
+
+func (t *memTransport) target(from, to string) (*Node, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.down[from] || t.down[to] || t.nodes[to] == nil {
+		return nil, errors.New("unreachable")
+	}
+	return t.nodes[to], nil
+}
+
+type memClient struct {
+	t    *memTransport
+	self string
+}
+
+func (c memClient) RequestVote(ctx context.Context, peer string, args *VoteArgs) (*VoteReply, error) {
+	n, err := c.t.target(c.self, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleVote(args), nil
+}
+
+func (c memClient) AppendEntries(ctx context.Context, peer string, args *AppendArgs) (*AppendReply, error) {
+	n, err := c.t.target(c.self, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleAppend(args), nil
+}
+
+type testCluster struct {
+	t       *memTransport
+	nodes   []*Node
+	mu      sync.Mutex
+	applied map[string][]string // 每个节点按顺序应用的命令
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{
+		t:       &memTransport{nodes: make(map[string]*Node), down: make(map[string]bool)},
+		applied: make(map[string][]string),
+	}
+	var peers []string
+	for i := 0; i < size; i++ {
+		peers = append(peers, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range peers {
+		n, err := NewNode(Config{
+			ID:                id,
+			Peers:             peers,
+			Dir:               t.TempDir(),
+			HeartbeatInterval: 10 * time.Millisecond,
+			ElectionTimeout:   100 * time.Millisecond,
+			Transport:         memClient{t: c.t, self: id},
+			Apply: func(index uint64, command []byte) {
+				c.mu.Lock()
+				c.applied[id] = append(c.applied[id], string(command))
+				c.mu.Unlock()
+			},
+			Logf: t.Logf,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.t.mu.Lock()
+		c.t.nodes[id] = n
+		c.t.mu.Unlock()
+		c.nodes = append(c.nodes, n)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+	return c
+}
+
+// 等待出现一个不在skip中的leader
+func (c *testCluster) waitLeader(t *testing.T, skip string) *Node {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, n := range c.nodes {
+			if n.cfg.ID != skip && n.IsLeader() {
+				return n
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+// 等待所有节点都应用了want
+func (c *testCluster) waitApplied(t *testing.T, want []string) {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		ok := true
+		for _, n := range c.nodes {
+			if !slices.Equal(c.applied[n.cfg.ID], want) {
+				ok = false
+			}
+		}
+		c.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.Fatalf("nodes did not converge to %v, got %v", want, c.applied)
+}
+
+func TestReplicate(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.waitLeader(t, "")
+	for _, n := range c.nodes {
+		if n != leader {
+			if _, err := n.Apply(context.Background(), []byte("x")); !errors.Is(err, ErrNotLeader) {
+				t.Fatalf("follower accepted command, err=%v", err)
+			}
+		}
+	}
+	for _, cmd := range []string{"a", "b", "c"} {
+		if _, err := leader.Apply(context.Background(), []byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.waitApplied(t, []string{"a", "b", "c"})
+}
+
+func TestPartitionedLeader(t *testing.T) {
+	c := newTestCluster(t, 3)
+	old := c.waitLeader(t, "")
+	if _, err := old.Apply(context.Background(), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	c.t.mu.Lock()
+	c.t.down[old.cfg.ID] = true
+	c.t.mu.Unlock()
+
+	// 被分区的leader无法提交新的命令，并且会退位
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := old.Apply(ctx, []byte("lost")); err == nil {
+		t.Fatal("partitioned leader committed a command")
+	}
+	leader := c.waitLeader(t, old.cfg.ID)
+	if old.IsLeader() {
+		t.Fatal("partitioned leader did not step down")
+	}
+	if _, err := leader.Apply(context.Background(), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 恢复之后旧leader未提交的日志被覆盖，所有节点的状态一致
+	c.t.mu.Lock()
+	c.t.down[old.cfg.ID] = false
+	c.t.mu.Unlock()
+	c.waitApplied(t, []string{"a", "b"})
+}
+
+func TestRestart(t *testing.T) {
+	dir := t.TempDir()
+	var applied []string
+	cfg := Config{
+		ID:                "n0",
+		Dir:               dir,
+		HeartbeatInterval: 10 * time.Millisecond,
+		ElectionTimeout:   50 * time.Millisecond,
+		Transport:         memClient{t: &memTransport{}},
+		Apply: func(index uint64, command []byte) {
+			applied = append(applied, string(command))
+		},
+		Logf: t.Logf,
+	}
+	n, err := NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !n.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := n.Apply(context.Background(), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	term := n.Status().Term
+	n.Stop()
+
+	// 重启之后从磁盘恢复日志，再次成为leader后重新应用
+	applied = nil
+	n, err = NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+	if err := waitBarrier(n); err != nil {
+		t.Fatal(err)
+	}
+	if s := n.Status(); s.Term <= term || !slices.Equal(applied, []string{"a"}) {
+		t.Fatalf("state not restored, term %d -> %d, applied %v", term, s.Term, applied)
+	}
+}
+
+func waitBarrier(n *Node) error {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if n.IsLeader() {
+			return n.Barrier(context.Background())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return errors.New("not leader")
+}
+
+// 不会主动发起选举的节点，只处理其他节点的请求
+func newPassiveNode(t *testing.T, dir string) *Node {
+	n, err := NewNode(Config{
+		ID:              "n0",
+		Peers:           []string{"n0", "n1", "n2"},
+		Dir:             dir,
+		ElectionTimeout: time.Hour,
+		Transport:       memClient{t: &memTransport{}},
+		Logf:            t.Logf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPersistLog(t *testing.T) {
+	dir := t.TempDir()
+	n := newPassiveNode(t, dir)
+	entries := []Entry{{Index: 1, Term: 1, Command: []byte("a")}, {Index: 2, Term: 1, Command: []byte("b")}, {Index: 3, Term: 1, Command: []byte("c")}}
+	if r := n.HandleAppend(&AppendArgs{Term: 1, Leader: "n1", Entries: entries}); !r.Success {
+		t.Fatal("append failed")
+	}
+	// 冲突的日志被截断，新的日志追加到文件之后
+	if r := n.HandleAppend(&AppendArgs{Term: 2, Leader: "n2", PrevLogIndex: 1, PrevLogTerm: 1, Entries: []Entry{{Index: 2, Term: 2, Command: []byte("d")}}}); !r.Success {
+		t.Fatal("append failed")
+	}
+	n.Stop()
+
+	n = newPassiveNode(t, dir)
+	defer n.Stop()
+	if s := n.Status(); s.Term != 2 || s.LastIndex != 2 || string(n.log[2].Command) != "d" {
+		t.Fatalf("log not restored, got %+v %v", s, n.log)
+	}
+}
+
+func TestPersistFailure(t *testing.T) {
+	dir := t.TempDir()
+	n := newPassiveNode(t, dir)
+	defer n.Stop()
+	// 状态无法写入磁盘时拒绝投票与日志
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if r := n.HandleVote(&VoteArgs{Term: 1, Candidate: "n1"}); r.Granted {
+		t.Fatal("vote granted without persisting")
+	}
+	if r := n.HandleAppend(&AppendArgs{Term: 1, Leader: "n1", Entries: []Entry{{Index: 1, Term: 1}}}); r.Success || !r.PersistFailed {
+		t.Fatalf("append accepted without persisting, got %+v", r)
+	}
+	if s := n.Status(); s.LastIndex != 0 {
+		t.Fatalf("log not rolled back, got %+v", s)
+	}
+
+	// leader收到写入失败的响应后停止发送，不会回退nextIndex
+	n.mu.Lock()
+	n.state, n.nextIndex["n1"] = Leader, 5
+	more := n.handleAppendReply("n1", &AppendArgs{Term: n.term, PrevLogIndex: 4}, &AppendReply{Term: n.term, PersistFailed: true})
+	next := n.nextIndex["n1"]
+	n.state = Follower
+	n.mu.Unlock()
+	if more || next != 5 {
+		t.Fatalf("expect replication to pause, got more %v next %d", more, next)
+	}
+
+	// 磁盘恢复之后可以继续追加
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if r := n.HandleAppend(&AppendArgs{Term: 1, Leader: "n1", Entries: []Entry{{Index: 1, Term: 1}}}); !r.Success {
+		t.Fatalf("append failed after disk recovered, got %+v", r)
+	}
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRaftCatalog(t *testing.T) {
+	if err := SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDataDir(".")
+	// 新的集群从空的目录开始
+	catalogMu.Lock()
+	catalog = Catalog{Groups: make(map[string]CatalogEntry)}
+	catalogMu.Unlock()
+	name := "raft-test"
+	defer DeleteGroup(name)
+	pools := make([]*HTTPPool, 3)
+	var urls []string
+	for i := range pools {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	for i := range pools {
+		pools[i] = NewHTTPPool(urls[i])
+	}
+	for _, p := range pools {
+		if err := p.StartRaft(t.TempDir(), urls); err != nil {
+			t.Fatal(err)
+		}
+		defer p.raft.Stop()
+	}
+
+	var follower *HTTPPool
+	deadline := time.Now().Add(5 * time.Second)
+	for follower == nil && time.Now().Before(deadline) {
+		for i, p := range pools {
+			if p.raft.IsLeader() {
+				follower = pools[(i+1)%len(pools)]
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if follower == nil {
+		t.Fatal("no leader elected")
+	}
+
+	// 发给follower的请求被转发给leader，通过raft提交之后应用
+	res, err := http.Get(follower.self + "/CreateGroup?group_name=" + name + "&cache_bytes=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("create group through follower failed: %s", res.Status)
+	}
+	g := GetGroup(name)
+	if g == nil || g.Info().CacheBytes != 4096 {
+		t.Fatalf("group not created, got %v", g)
+	}
+	if e := GetCatalog().Groups[name]; e.Version == 0 || e.Version > pools[0].raft.Status().LastIndex {
+		t.Fatalf("catalog version should be the raft index, got %d", e.Version)
+	}
+
+	// 其他节点发送的目录同样转发给leader并通过raft提交，不会直接合并
+	remote := Catalog{Version: 1 << 20, Groups: map[string]CatalogEntry{name: {Info: GroupInfo{Name: name, CacheBytes: 8192}, Version: 1 << 20, Origin: "peer"}}}
+	body, _ := json.Marshal(&remote)
+	res, err = http.Post(follower.self+"/ApplyCatalog", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("apply catalog through follower failed: %s", res.Status)
+	}
+	if g := GetGroup(name); g == nil || g.Info().CacheBytes != 8192 {
+		t.Fatalf("catalog not applied, got %v", g)
+	}
+	if e := GetCatalog().Groups[name]; e.Version > pools[0].raft.Status().LastIndex {
+		t.Fatalf("merged entry should be committed through raft, got version %d", e.Version)
+	}
+
+	// 所有节点应用相同的日志
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		applied := map[uint64]bool{}
+		for _, p := range pools {
+			applied[p.raft.Status().LastApplied] = true
+		}
+		if len(applied) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes did not converge, applied %v", applied)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+)
+
+//软过期与提前刷新，超过软过期时间的数据仍然会被返回，同时在后台通过getter刷新，
+//开启提前刷新后，临近过期的热点数据会按照一定的概率提前在后台刷新，避免请求在数据过期时等待加载
+
+// 为从getter加载的数据加上软过期时间
+func (g *Group) withSoftTTL(value ByteView) ByteView {
+	if g.softTTL > 0 {
+		value.s = time.Now().Add(g.softTTL)
+	}
+	return value
+}
+
+// 内存命中之后调用，数据已经软过期或者需要提前刷新时在后台刷新
+func (g *Group) maybeRefresh(key string, v ByteView) {
+	if g.getter == nil {
+		return
+	}
+	// 写入的数据带有版本并且没有软过期时间，只刷新从getter或者其他节点加载的数据，
+	// 否则写入的数据可能被数据源中的旧数据覆盖，或者在数据源中不存在时被删除
+	if v.v != 0 && v.s.IsZero() {
+		return
+	}
+	now := time.Now()
+	if v.stale(now) {
+		g.Stats.StaleHits.Add(1)
+		g.refresh(key)
+		return
+	}
+	if g.shouldRefreshEarly(v, now) {
+		g.refresh(key)
+	}
+}
+
+// 使用XFetch算法判断是否提前刷新：now - loadTime*beta*ln(rand) >= 过期时间时刷新，
+// 越接近过期、加载越慢的数据越容易被刷新，访问越频繁的数据被提前刷新的机会越多
+func (g *Group) shouldRefreshEarly(v ByteView, now time.Time) bool {
+	if g.refreshBeta <= 0 {
+		return false
+	}
+	expire := v.s
+	if expire.IsZero() {
+		expire = v.e
+	}
+	delta := g.loadTime.Load()
+	if expire.IsZero() || delta <= 0 {
+		return false
+	}
+	gap := time.Duration(float64(delta) * g.refreshBeta * -math.Log(1-rand.Float64()))
+	return !now.Add(gap).Before(expire)
+}
+
+// 在后台通过loader重新加载数据，同一个键同时只会有一个刷新过程，刷新失败时保留旧数据，
+// 数据源中已经不存在该键时将其删除
+func (g *Group) refresh(key string) {
+	g.refreshMu.Lock()
+	if g.refreshing[key] {
+		g.refreshMu.Unlock()
+		return
+	}
+	g.refreshing[key] = true
+	g.refreshMu.Unlock()
+	g.Stats.Refreshes.Add(1)
+	go func() {
+		defer func() {
+			g.refreshMu.Lock()
+			delete(g.refreshing, key)
+			g.refreshMu.Unlock()
+		}()
+		// 与普通的加载相同，由负责该键的节点刷新，集群中同一个键只访问一次数据源
+		v, err := g.load(context.Background(), key, true)
+		if err == nil {
+			// 从远程节点获得的数据不会写入本地缓存，用刷新的结果替换本地的旧数据
+			g.populateCache(key, v)
+		} else if errors.Is(err, ErrNotFound) {
+			g.mainCache.delete(key)
+		} else if err != nil {
+			log.Printf("[Zcache] failed to refresh %s of group %s: %v", key, g.name, err)
+		}
+	}()
+}
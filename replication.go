@@ -0,0 +1,445 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//主从复制，主节点把对数据与组的修改按顺序记录在内存中的复制日志里，每条记录带有递增的偏移量，
+//日志超过最大内存时丢弃最旧的记录。节点在第一次被副本全量同步时才开始记录，没有副本的节点不占用复制日志的内存。配置为副本的节点从主节点拉取偏移量之后的记录并按顺序应用，
+//副本落后太多(需要的记录已经被丢弃)或者主节点重启(复制ID变化)时，先从主节点拉取全量数据，再从全量数据对应的偏移量继续拉取。
+//副本只提供读服务，可以通过ReplPromote接口将副本提升为主节点
+
+const (
+	// 复制日志默认的最大内存
+	defaultReplLogBytes = 16 << 20
+	// 没有新的记录时，拉取请求在主节点上等待的最长时间
+	replWaitTimeout = time.Second
+	// 一次拉取的最大记录数
+	replBatchSize = 1000
+	// 拉取失败之后的重试间隔
+	replRetryInterval = time.Second
+
+	replIDHeader     = "X-Zcache-Repl-Id"
+	replOffsetHeader = "X-Zcache-Repl-Offset"
+)
+
+// 复制日志中记录的操作
+const (
+	replSet    = "set"    // 设置键值对
+	replDelete = "delete" // 删除键
+	replClear  = "clear"  // 清空组
+	replGroup  = "group"  // 创建组或者修改组的配置
+	replDrop   = "drop"   // 删除组
+)
+
+// ReplOp 复制日志中的一条记录
+type ReplOp struct {
+	Offset  uint64
+	Op      string
+	Group   string
+	Key     string     `json:",omitempty"`
+	Value   []byte     `json:",omitempty"`
+	Expire  int64      `json:",omitempty"` // 过期时间，UnixNano，0表示不过期
+	Version uint64     `json:",omitempty"` // 写入时的版本，副本只应用比本地更新的版本，0表示没有版本
+	Info    *GroupInfo `json:",omitempty"`
+}
+
+// 主节点对拉取请求的响应
+type replBatch struct {
+	ID   string
+	Head uint64 // 主节点最新的偏移量
+	Ops  []ReplOp
+}
+
+// ReplicaInfo 主节点记录的副本状态
+type ReplicaInfo struct {
+	Addr     string
+	Offset   uint64
+	Lag      uint64
+	LastSeen time.Time
+}
+
+// ReplStatus 节点的复制状态
+type ReplStatus struct {
+	Role     string        // primary或者replica
+	ID       string        // 复制ID，副本为正在复制的主节点的复制ID
+	Offset   uint64        // 主节点为最新的偏移量，副本为已经应用的偏移量
+	Primary  string        `json:",omitempty"`
+	Lag      uint64        `json:",omitempty"` // 副本落后主节点的记录数
+	Replicas []ReplicaInfo `json:",omitempty"`
+}
+
+// 复制日志
+type replLog struct {
+	mu       sync.Mutex
+	id       string // 复制ID，每次启动时随机生成
+	ops      []ReplOp
+	bytes    int64
+	maxBytes int64
+	head     uint64        // 最新一条记录的偏移量
+	enabled  bool          // 是否记录，第一个副本全量同步时开启
+	notify   chan struct{} // 有新的记录时被关闭
+	replicas map[string]ReplicaInfo
+}
+
+var replication = newReplLog(defaultReplLogBytes)
+
+func newReplLog(maxBytes int64) *replLog {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return &replLog{
+		id:       hex.EncodeToString(b),
+		maxBytes: maxBytes,
+		notify:   make(chan struct{}),
+		replicas: make(map[string]ReplicaInfo),
+	}
+}
+
+// SetReplicationLog 设置复制日志的最大内存，maxBytes不大于0时使用默认值
+func SetReplicationLog(maxBytes int64) {
+	if maxBytes <= 0 {
+		maxBytes = defaultReplLogBytes
+	}
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	replication.maxBytes = maxBytes
+	replication.trim()
+}
+
+func opBytes(op *ReplOp) int64 {
+	return int64(len(op.Group)+len(op.Key)+len(op.Value)) + 64
+}
+
+// 开始记录，之后的修改都会追加到日志中
+func (l *replLog) enable() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = true
+}
+
+// 追加一条记录，还没有副本时不记录
+func (l *replLog) append(op ReplOp) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.enabled {
+		return
+	}
+	l.head++
+	op.Offset = l.head
+	l.ops = append(l.ops, op)
+	l.bytes += opBytes(&op)
+	l.trim()
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// 丢弃最旧的记录直到不超过最大内存，最新的记录总是保留，否则超过最大内存的单条记录会使副本无法增量同步，
+// 在持有锁的情况下调用
+func (l *replLog) trim() {
+	i := 0
+	for l.bytes > l.maxBytes && i < len(l.ops)-1 {
+		l.bytes -= opBytes(&l.ops[i])
+		i++
+	}
+	if i > 0 {
+		l.ops = append([]ReplOp(nil), l.ops[i:]...)
+	}
+}
+
+// 获得offset之后的记录，还没有开始记录、复制ID不同或者需要的记录已经被丢弃时返回false，没有新的记录时最多等待ctx结束
+func (l *replLog) since(ctx context.Context, id string, offset uint64) (replBatch, bool) {
+	for {
+		l.mu.Lock()
+		batch := replBatch{ID: l.id, Head: l.head}
+		first := l.head + 1 - uint64(len(l.ops))
+		if !l.enabled || id != l.id || offset > l.head || offset+1 < first {
+			l.mu.Unlock()
+			return batch, false
+		}
+		if offset < l.head {
+			start := offset + 1 - first
+			end := min(uint64(len(l.ops)), start+replBatchSize)
+			batch.Ops = append([]ReplOp(nil), l.ops[start:end]...)
+			l.mu.Unlock()
+			return batch, true
+		}
+		notify := l.notify
+		l.mu.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return batch, true
+		}
+	}
+}
+
+// 当前的复制ID与最新的偏移量
+func (l *replLog) position() (string, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.id, l.head
+}
+
+// 记录副本拉取到的位置
+func (l *replLog) seen(addr string, offset uint64) {
+	if addr == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.replicas[addr] = ReplicaInfo{Addr: addr, Offset: offset, LastSeen: time.Now()}
+}
+
+// 记录对组中数据的修改
+func (g *Group) replicate(op string, key string, value ByteView) {
+	r := ReplOp{Op: op, Group: g.name, Key: key, Value: value.b, Version: value.v}
+	if !value.e.IsZero() {
+		r.Expire = value.e.UnixNano()
+	}
+	replication.append(r)
+}
+
+// 记录组的创建、修改或删除
+func replicateGroup(info GroupInfo, drop bool) {
+	if drop {
+		replication.append(ReplOp{Op: replDrop, Group: info.Name})
+		return
+	}
+	replication.append(ReplOp{Op: replGroup, Group: info.Name, Info: &info})
+}
+
+// 在副本上应用一条记录，应用时同样会记录到副本自己的复制日志中，因此副本也可以作为其他副本的主节点
+func applyReplOp(op *ReplOp) {
+	switch op.Op {
+	case replGroup:
+		if op.Info == nil {
+			return
+		}
+		if g := GetGroup(op.Group); g != nil {
+			g.reconfigure(*op.Info)
+			return
+		}
+		NewGroupWithInfo(*op.Info, nil)
+		return
+	case replDrop:
+		DeleteGroup(op.Group)
+		return
+	}
+	g := GetGroup(op.Group)
+	if g == nil {
+		return
+	}
+	switch op.Op {
+	case replSet:
+		v := ByteView{b: op.Value, v: op.Version}
+		if op.Expire != 0 {
+			v.e = time.Unix(0, op.Expire)
+		}
+		// 带有版本的记录只在比本地更新时应用，保留主节点写入时的版本
+		if v.v != 0 {
+			g.setVersioned(op.Key, v)
+			return
+		}
+		g.SetList([]string{op.Key}, []ByteView{v})
+	case replDelete:
+		if op.Version != 0 {
+			g.deleteVersion(op.Key, op.Version)
+			return
+		}
+		g.deleteLocal(op.Key)
+	case replClear:
+		g.clear()
+	}
+}
+
+//---------------------------------------------------------------------------------------------------------------------
+
+// 副本的复制状态
+type replica struct {
+	pool    *HTTPPool
+	primary *HttpGetter
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu     sync.Mutex
+	id     string // 正在复制的主节点的复制ID，为空时需要全量同步
+	offset uint64
+	head   uint64
+}
+
+// StartReplica 将节点配置为primary的副本，开始从primary复制数据，副本只提供读服务
+func (p *HTTPPool) StartReplica(primary string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &replica{
+		pool:    p,
+		primary: &HttpGetter{BaseURL: primary, Client: p.client},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	p.replica.Store(r)
+	go r.run(ctx)
+}
+
+// Promote 停止复制，将副本提升为主节点，之后可以接受写请求
+func (p *HTTPPool) Promote() bool {
+	r := p.replica.Swap(nil)
+	if r == nil {
+		return false
+	}
+	r.cancel()
+	<-r.done
+	p.Log("promoted to primary")
+	return true
+}
+
+// ReplStatus 获得节点的复制状态
+func (p *HTTPPool) ReplStatus() ReplStatus {
+	if r := p.replica.Load(); r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return ReplStatus{Role: "replica", ID: r.id, Offset: r.offset, Primary: r.primary.BaseURL, Lag: r.head - r.offset}
+	}
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	s := ReplStatus{Role: "primary", ID: replication.id, Offset: replication.head}
+	for _, info := range replication.replicas {
+		info.Lag = replication.head - min(info.Offset, replication.head)
+		s.Replicas = append(s.Replicas, info)
+	}
+	sort.Slice(s.Replicas, func(i, j int) bool {
+		return s.Replicas[i].Addr < s.Replicas[j].Addr
+	})
+	return s
+}
+
+// 节点是否为只读的副本
+func (p *HTTPPool) readOnly() bool {
+	return p.replica.Load() != nil
+}
+
+func (r *replica) run(ctx context.Context) {
+	defer close(r.done)
+	for ctx.Err() == nil {
+		r.mu.Lock()
+		id := r.id
+		r.mu.Unlock()
+		var err error
+		if id == "" {
+			err = r.sync(ctx)
+		} else {
+			err = r.pull(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			r.pool.Log("replicate from %s failed: %v", r.primary.BaseURL, err)
+			select {
+			case <-time.After(replRetryInterval):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// 拉取并应用一批记录，主节点无法提供需要的记录时清空复制ID，下一次进行全量同步
+func (r *replica) pull(ctx context.Context) error {
+	r.mu.Lock()
+	u := fmt.Sprintf("%v/ReplStream?id=%v&offset=%d&replica=%v",
+		r.primary.BaseURL, url.QueryEscape(r.id), r.offset, url.QueryEscape(r.pool.self))
+	r.mu.Unlock()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.primary.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusGone {
+		_, _ = io.Copy(io.Discard, res.Body)
+		r.mu.Lock()
+		r.id = ""
+		r.mu.Unlock()
+		r.pool.Log("replica fell behind %s, full resync", r.primary.BaseURL)
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	batch := replBatch{}
+	if err := json.NewDecoder(res.Body).Decode(&batch); err != nil {
+		return err
+	}
+	for i := range batch.Ops {
+		applyReplOp(&batch.Ops[i])
+	}
+	r.mu.Lock()
+	if n := len(batch.Ops); n > 0 {
+		r.offset = batch.Ops[n-1].Offset
+	}
+	r.head = batch.Head
+	r.mu.Unlock()
+	return nil
+}
+
+// 从主节点拉取全量数据，替换副本中所有的组
+func (r *replica) sync(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.primary.BaseURL+"/ReplSnapshot", nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.primary.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	id := res.Header.Get(replIDHeader)
+	offset, err := strconv.ParseUint(res.Header.Get(replOffsetHeader), 10, 64)
+	if id == "" || err != nil {
+		return errors.New("invalid snapshot header")
+	}
+	seen := make(map[string]bool)
+	if _, err := loadPersistence(res.Body, loadOptions{replace: true, seen: seen}); err != nil {
+		return err
+	}
+	for _, name := range GetGroupList() {
+		if !seen[name] {
+			DeleteGroup(name)
+		}
+	}
+	r.mu.Lock()
+	r.id, r.offset, r.head = id, offset, offset
+	r.mu.Unlock()
+	r.pool.Log("full resync from %s at offset %d", r.primary.BaseURL, offset)
+	return nil
+}
+
+// 将所有组的全量数据写入w，返回数据对应的复制ID与偏移量，之后的记录从该偏移量之后开始，
+// 在导出之前开始记录，导出期间的修改不会丢失
+func writeReplSnapshot(w http.ResponseWriter) {
+	replication.enable()
+	id, offset := replication.position()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(replIDHeader, id)
+	w.Header().Set(replOffsetHeader, strconv.FormatUint(offset, 10))
+	for _, name := range GetGroupList() {
+		// 组可能在导出期间被删除
+		_ = exportGroupFiltered(name, w, nil)
+	}
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReplLog(t *testing.T) {
+	l := newReplLog(3 * 70)
+	// 没有副本全量同步之前不记录
+	l.append(ReplOp{Op: replSet, Group: "g", Key: "k"})
+	if _, ok := l.since(context.Background(), l.id, 0); ok || l.head != 0 {
+		t.Fatal("expect no ops before a replica connects")
+	}
+	l.enable()
+	for i := range 3 {
+		l.append(ReplOp{Op: replSet, Group: "g", Key: "k" + strconv.Itoa(i)})
+	}
+	ctx := context.Background()
+	if b, ok := l.since(ctx, l.id, 1); !ok || len(b.Ops) != 2 || b.Ops[0].Offset != 2 || b.Head != 3 {
+		t.Fatalf("expect ops after offset 1, got %+v", b)
+	}
+	if _, ok := l.since(ctx, "other", 1); ok {
+		t.Fatal("expect resync for a different replication id")
+	}
+	// 超过最大内存时丢弃最旧的记录，需要这些记录的副本进行全量同步
+	l.append(ReplOp{Op: replSet, Group: "g", Key: "k3"})
+	if _, ok := l.since(ctx, l.id, 0); ok {
+		t.Fatal("expect resync after the log is trimmed")
+	}
+	if b, ok := l.since(ctx, l.id, 1); !ok || len(b.Ops) != 3 {
+		t.Fatalf("expect ops after offset 1, got %+v", b)
+	}
+	// 超过最大内存的单条记录仍然保留
+	big := newReplLog(70)
+	big.enable()
+	big.append(ReplOp{Op: replSet, Group: "g", Key: "big", Value: make([]byte, 100)})
+	if b, ok := big.since(ctx, big.id, 0); !ok || len(b.Ops) != 1 {
+		t.Fatalf("expect the newest op to be kept, got %+v", b)
+	}
+	// 没有新的记录时等待
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		l.append(ReplOp{Op: replDelete, Group: "g", Key: "k0"})
+	}()
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if b, ok := l.since(ctx, l.id, 4); !ok || len(b.Ops) != 1 || b.Ops[0].Op != replDelete {
+		t.Fatalf("expect the new op, got %+v", b)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	name := "replica-test"
+	defer DeleteGroup(name)
+	g := NewGroupWithInfo(GroupInfo{Name: name, CacheBytes: 2048}, nil)
+	g.Set("k1", ByteView{b: []byte("v1")})
+	snapshot := bytes.Buffer{}
+	if err := g.SaveGroup(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	DeleteGroup(name)
+
+	// 只有offset为5之后的两条记录，其他的复制ID需要全量同步
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/ReplSnapshot":
+			w.Header().Set(replIDHeader, "primary")
+			w.Header().Set(replOffsetHeader, "5")
+			_, _ = w.Write(snapshot.Bytes())
+		case "/ReplStream":
+			if q.Get("id") != "primary" {
+				http.Error(w, "full resync required", http.StatusGone)
+				return
+			}
+			batch := replBatch{ID: "primary", Head: 7}
+			if q.Get("offset") == "5" {
+				batch.Ops = []ReplOp{
+					{Offset: 6, Op: replSet, Group: name, Key: "k2", Value: []byte("v2"), Version: 42},
+					{Offset: 7, Op: replDelete, Group: name, Key: "k1"},
+				}
+			} else {
+				time.Sleep(10 * time.Millisecond)
+			}
+			_ = json.NewEncoder(w).Encode(batch)
+		}
+	}))
+	defer primary.Close()
+
+	p := NewHTTPPool("http://replica")
+	p.StartReplica(primary.URL)
+	defer p.Promote()
+	deadline := time.Now().Add(3 * time.Second)
+	for p.ReplStatus().Offset != 7 {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica did not catch up, status %+v", p.ReplStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	g = GetGroup(name)
+	if g == nil {
+		t.Fatal("group not synced")
+	}
+	if v, ok := g.mainCache.get("k2"); !ok || v.String() != "v2" || v.v != 42 {
+		t.Fatal("op after snapshot not applied")
+	}
+	if _, ok := g.mainCache.get("k1"); ok {
+		t.Fatal("delete after snapshot not applied")
+	}
+
+	// 副本拒绝写请求，提升为主节点之后可以写入
+	write := func() int {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/DeleteData?group="+name+"&key=k2", nil))
+		return w.Code
+	}
+	if code := write(); code != http.StatusForbidden {
+		t.Fatalf("expect replica to reject writes, got %d", code)
+	}
+	// 副本只处理其他节点发送的失效通知，拒绝客户端的失效请求
+	invalidate := func(path string, forwarded bool) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte("[]")))
+		if forwarded {
+			r.Header.Set(forwardedHeader, "1")
+		}
+		p.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := invalidate("/InvalidateBatch", true); code != http.StatusOK {
+		t.Fatalf("expect replica to accept invalidations from peers, got %d", code)
+	}
+	if code := invalidate("/InvalidateBatch", false); code != http.StatusForbidden {
+		t.Fatalf("expect replica to reject invalidations from clients, got %d", code)
+	}
+	if code := invalidate("/Invalidate?group="+name+"&key=k2", false); code != http.StatusForbidden {
+		t.Fatalf("expect replica to reject invalidate, got %d", code)
+	}
+	if !p.Promote() || p.ReplStatus().Role != "primary" {
+		t.Fatal("promote failed")
+	}
+	if code := write(); code != http.StatusOK {
+		t.Fatalf("expect promoted node to accept writes, got %d", code)
+	}
+}
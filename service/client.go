@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"cache"
 	"cache/cachepb/cachepb"
+	"cache/raft"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 type Client struct {
@@ -16,7 +22,15 @@ type Client struct {
 }
 
 func NewClient(URL string) *Client {
-	return &Client{cache.HttpGetter{BaseURL: URL}}
+	return NewClientWithHTTP(URL, nil)
+}
+
+// NewClientWithHTTP 创建一个使用client发送请求的客户端，client为nil时使用默认配置的客户端
+func NewClientWithHTTP(URL string, client *http.Client) *Client {
+	if client == nil {
+		client = cache.NewHTTPClient(cache.HTTPOptions{})
+	}
+	return &Client{cache.HttpGetter{BaseURL: URL, Client: client}}
 }
 
 // Get 向缓存读取数据
@@ -32,7 +46,7 @@ func (c *Client) Set(in *cachepb.SetRequest, out *cachepb.Response) error {
 		"GetData",
 	)
 	data, _ := proto.Marshal(in)
-	res, err := http.Post(u, "application/octet-stream", bytes.NewReader(data))
+	res, err := c.HTTPClient().Post(u, "application/octet-stream", bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -54,7 +68,7 @@ func (c *Client) Set(in *cachepb.SetRequest, out *cachepb.Response) error {
 // Delete 删除缓存中的一个组
 func (c *Client) Delete(in *cachepb.DeleteRequest, out *cachepb.Response) error {
 	u := fmt.Sprintf("%v/%v?group=%v&key=%v", c.BaseURL, "DeleteData", in.Group, in.Key)
-	res, err := http.Get(u)
+	res, err := c.HTTPClient().Get(u)
 	if err != nil {
 		return err
 	}
@@ -73,16 +87,100 @@ func (c *Client) Delete(in *cachepb.DeleteRequest, out *cachepb.Response) error
 	return nil
 }
 
-// CreateGroup 向缓存中创建一个组
-func (c *Client) CreateGroup(groupName string) {
-	u := fmt.Sprintf("%v/%v?group_name=%v", c.BaseURL, "CreateGroup", groupName)
-	http.Get(u)
+// CreateGroup 在集群中创建一个组，组已经存在时修改它的容量，cacheBytes为0时使用默认容量
+func (c *Client) CreateGroup(groupName string, cacheBytes int64) error {
+	u := fmt.Sprintf("%v/%v?group_name=%v", c.BaseURL, "CreateGroup", url.QueryEscape(groupName))
+	if cacheBytes > 0 {
+		u += "&cache_bytes=" + strconv.FormatInt(cacheBytes, 10)
+	}
+	return c.getEmpty(u)
+}
+
+// UpdateGroup 修改集群中一个组的容量
+func (c *Client) UpdateGroup(groupName string, cacheBytes int64) error {
+	u := fmt.Sprintf("%v/%v?group=%v&cache_bytes=%v", c.BaseURL, "UpdateGroup", url.QueryEscape(groupName), cacheBytes)
+	return c.getEmpty(u)
+}
+
+// GetCatalog 获得集群的组目录，开启raft时由leader返回
+func (c *Client) GetCatalog(out *cache.Catalog) error {
+	return c.getJSON(c.BaseURL+"/GetCatalog?consistent=1", out)
+}
+
+// RaftStatus 获得节点的raft状态
+func (c *Client) RaftStatus(out *raft.Status) error {
+	return c.getJSON(c.BaseURL+"/RaftStatus", out)
+}
+
+// SetPeers 通过raft修改集群中的节点列表
+func (c *Client) SetPeers(peers []string) error {
+	u := fmt.Sprintf("%v/%v?peers=%v", c.BaseURL, "SetPeers", url.QueryEscape(strings.Join(peers, ",")))
+	return c.getEmpty(u)
+}
+
+// ReplStatus 获得节点的复制状态
+func (c *Client) ReplStatus(out *cache.ReplStatus) error {
+	return c.getJSON(c.BaseURL+"/ReplStatus", out)
+}
+
+// Promote 将副本提升为主节点
+func (c *Client) Promote() error {
+	return c.getEmpty(c.BaseURL + "/ReplPromote")
+}
+
+// AntiEntropy 让节点立即进行一轮反熵修复，返回修复之后的状态
+func (c *Client) AntiEntropy(out *cache.AntiEntropyStatus) error {
+	return c.getJSON(c.BaseURL+"/AntiEntropy", out)
+}
+
+// AntiEntropyStatus 获得节点反熵修复的状态
+func (c *Client) AntiEntropyStatus(out *cache.AntiEntropyStatus) error {
+	return c.getJSON(c.BaseURL+"/AntiEntropyStatus", out)
+}
+
+// Invalidate 使集群中所有节点缓存中的键失效，不会从数据源删除
+func (c *Client) Invalidate(groupName string, key string) error {
+	u := fmt.Sprintf("%v/%v?group=%v&key=%v", c.BaseURL, "Invalidate", url.QueryEscape(groupName), url.QueryEscape(key))
+	return c.getEmpty(u)
+}
+
+// Hints 获得节点上等待重放的提示
+func (c *Client) Hints(out *cache.HintStatus) error {
+	return c.getJSON(c.BaseURL+"/Hints", out)
+}
+
+// 发送请求并将JSON格式的响应解码到out中
+func (c *Client) getJSON(u string, out any) error {
+	res, err := c.HTTPClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// 发送没有响应内容的请求
+func (c *Client) getEmpty(u string) error {
+	res, err := c.HTTPClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return errors.New(string(s))
+	}
+	return nil
 }
 
 // GetGroupList 获得所有组的列表
 func (c *Client) GetGroupList(out *cachepb.GroupList) error {
 	u := fmt.Sprintf("%v/%v", c.BaseURL, "GetGroups")
-	res, err := http.Get(u)
+	res, err := c.HTTPClient().Get(u)
 	if err != nil {
 		return err
 	}
@@ -104,7 +202,7 @@ func (c *Client) GetGroupList(out *cachepb.GroupList) error {
 // GetGroupKeyList 获取一个组中的所有键
 func (c *Client) GetGroupKeyList(groupName string, out *cachepb.GroupKeyList) error {
 	u := fmt.Sprintf("%v/%v?group_name=%v", c.BaseURL, "GetGroupKeyList", groupName)
-	res, err := http.Get(u)
+	res, err := c.HTTPClient().Get(u)
 	if err != nil {
 		return err
 	}
@@ -125,7 +223,7 @@ func (c *Client) GetGroupKeyList(groupName string, out *cachepb.GroupKeyList) er
 
 func (c *Client) DeleteGroup(groupName string) error {
 	u := fmt.Sprintf("%v/%v?group=%v", c.BaseURL, "DeleteGroup", groupName)
-	res, err := http.Get(u)
+	res, err := c.HTTPClient().Get(u)
 	if err != nil {
 		return err
 	}
@@ -136,3 +234,121 @@ func (c *Client) DeleteGroup(groupName string) error {
 	}
 	return nil
 }
+
+// SaveSnapshot 立即将服务器中的数据保存为名为name的快照
+func (c *Client) SaveSnapshot(name string, out *cachepb.SnapshotInfo) error {
+	u := fmt.Sprintf("%v/%v?name=%v", c.BaseURL, "SaveSnapshot", url.QueryEscape(name))
+	res, err := c.HTTPClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, out)
+}
+
+// ListSnapshots 获得服务器上所有可用的快照
+func (c *Client) ListSnapshots(out *cachepb.SnapshotList) error {
+	u := fmt.Sprintf("%v/%v", c.BaseURL, "ListSnapshots")
+	res, err := c.HTTPClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, out)
+}
+
+// RestoreSnapshot 将快照恢复到服务器中，groupName为空时恢复所有组
+func (c *Client) RestoreSnapshot(name string, groupName string) error {
+	u := fmt.Sprintf("%v/%v?name=%v&group=%v", c.BaseURL, "RestoreSnapshot", url.QueryEscape(name), url.QueryEscape(groupName))
+	res, err := c.HTTPClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return errors.New(string(s))
+	}
+	return nil
+}
+
+// ExportGroup 将组中的数据以JSON Lines的格式导出到w中
+func (c *Client) ExportGroup(groupName string, w io.Writer) error {
+	u := fmt.Sprintf("%v/%v?group=%v", c.BaseURL, "ExportGroup", url.QueryEscape(groupName))
+	res, err := c.HTTPClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return errors.New(string(s))
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// ImportGroup 将JSON Lines格式的数据导入到服务器中，groupName为空时导入到数据中记录的组
+func (c *Client) ImportGroup(groupName string, r io.Reader) error {
+	u := fmt.Sprintf("%v/%v?group=%v", c.BaseURL, "ImportGroup", url.QueryEscape(groupName))
+	res, err := c.HTTPClient().Post(u, "application/x-ndjson", r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return errors.New(string(s))
+	}
+	return nil
+}
+
+// GetStats 获得组的统计信息，groupName为空时获得所有组的统计信息
+func (c *Client) GetStats(groupName string, out *cachepb.StatsList) error {
+	u := fmt.Sprintf("%v/%v?group=%v", c.BaseURL, "GetStats", url.QueryEscape(groupName))
+	res, err := c.HTTPClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		s, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v:%v", res.Status, string(s))
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, out)
+}
+
+// GetMulti 批量读取数据，不存在的键不会出现在结果中
+func (c *Client) GetMulti(in *cachepb.MultiGetRequest, out *cachepb.MultiGetResponse) error {
+	return c.HttpGetter.GetMulti(context.Background(), in, out)
+}
+
+// SetMulti 批量设置数据
+func (c *Client) SetMulti(in *cachepb.MultiSetRequest, out *cachepb.Response) error {
+	return c.HttpGetter.SetMulti(context.Background(), in, out)
+}
+
+// DeleteMulti 批量删除数据
+func (c *Client) DeleteMulti(in *cachepb.MultiDeleteRequest, out *cachepb.MultiDeleteResponse) error {
+	return c.HttpGetter.DeleteMulti(context.Background(), in, out)
+}
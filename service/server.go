@@ -2,11 +2,13 @@ package service
 
 import (
 	"cache"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"sync"
 	"syscall"
@@ -18,52 +20,179 @@ import (
 提供默认的api服务
 */
 
+// Config 服务器的配置，与配置文件中的项一一对应
+type Config struct {
+	IP              string `mapstructure:"ip"`               //服务器的ip地址
+	Port            int    `mapstructure:"port"`             //服务器的端口
+	Persistence     bool   `mapstructure:"persistence"`      //组默认是否开启持久化
+	PersistenceTime int    `mapstructure:"persistence-time"` //组默认的持久化间隔，单位为秒
+	DataDir         string `mapstructure:"data-dir"`         //组文件与快照所在的目录
+	//集群中所有节点的地址，如http://127.0.0.1:8999，为空时以单机模式运行
+	Peers []string `mapstructure:"peers"`
+	//没有从持久化文件中加载到数据时，是否从相邻节点拉取由自己负责的数据
+	WarmStart bool `mapstructure:"warm-start"`
+	//是否通过raft在peers之间复制组目录与节点列表，关闭时组目录在节点之间直接同步
+	Raft bool `mapstructure:"raft"`
+	//主节点的地址，不为空时节点作为该主节点的只读副本
+	ReplicaOf string `mapstructure:"replica-of"`
+	//复制日志的最大内存，单位为字节，0表示使用默认值
+	ReplLogBytes int64 `mapstructure:"repl-log-bytes"`
+	//反熵修复的间隔，单位为秒，0表示不开启
+	AntiEntropyInterval int `mapstructure:"anti-entropy-interval"`
+	//提示的最长保留时间，单位为秒，0表示使用默认值
+	HintMaxAge int `mapstructure:"hint-max-age"`
+	//每个节点的提示的最大空间，单位为字节，0表示使用默认值
+	HintMaxBytes int64 `mapstructure:"hint-max-bytes"`
+	//节点之间请求使用的http客户端的配置
+	HTTP HTTPConfig `mapstructure:"http"`
+}
+
+// HTTPConfig http客户端的配置，为0的项使用默认值
+type HTTPConfig struct {
+	MaxIdleConns        int  `mapstructure:"max-idle-conns"`          //所有节点总共的最大空闲连接数
+	MaxIdleConnsPerHost int  `mapstructure:"max-idle-conns-per-host"` //每个节点的最大空闲连接数
+	MaxConnsPerHost     int  `mapstructure:"max-conns-per-host"`      //每个节点的最大连接数，0表示不限制
+	IdleConnTimeout     int  `mapstructure:"idle-conn-timeout"`       //空闲连接的最长空闲时间，单位为秒
+	RequestTimeout      int  `mapstructure:"request-timeout"`         //单个请求的超时时间，单位为毫秒，0表示不限制
+	H2C                 bool `mapstructure:"h2c"`                     //是否在节点之间使用明文的HTTP/2
+}
+
+// Options 转换为http客户端的配置
+func (c HTTPConfig) Options() cache.HTTPOptions {
+	return cache.HTTPOptions{
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(c.IdleConnTimeout) * time.Second,
+		Timeout:             time.Duration(c.RequestTimeout) * time.Millisecond,
+		H2C:                 c.H2C,
+	}
+}
+
 type Server struct {
 	ip              string //服务器的ip地址
 	port            int    //服务器的端口
 	persistence     bool   //是否开启持久化
 	persistenceTime int    // 数据持久化的时间
+	dataDir         string //数据目录
+	peers           []string
+	warmStart       bool
+	raft            bool
+	replicaOf       string
+	replLogBytes    int64
+	antiEntropy     int
+	hintMaxAge      int
+	hintMaxBytes    int64
+	http            HTTPConfig
 }
 
-func NewServer(ip string, port int, persistence bool, persistenceTime int) *Server {
+func NewServer(c Config) *Server {
 	return &Server{
-		ip:              ip,
-		port:            port,
-		persistence:     persistence,
-		persistenceTime: persistenceTime,
+		ip:              c.IP,
+		port:            c.Port,
+		persistence:     c.Persistence,
+		persistenceTime: c.PersistenceTime,
+		dataDir:         c.DataDir,
+		peers:           c.Peers,
+		warmStart:       c.WarmStart,
+		raft:            c.Raft,
+		replicaOf:       c.ReplicaOf,
+		replLogBytes:    c.ReplLogBytes,
+		antiEntropy:     c.AntiEntropyInterval,
+		hintMaxAge:      c.HintMaxAge,
+		hintMaxBytes:    c.HintMaxBytes,
+		http:            c.HTTP,
 	}
 }
 
 func (s *Server) Run() {
+	if err := cache.SetDataDir(s.dataDir); err != nil {
+		panic(err)
+	}
+	cache.SetPersistence(s.persistence, time.Second*time.Duration(s.persistenceTime))
+	cache.SetReplicationLog(s.replLogBytes)
 	cache.NewGroup("default", 2048, nil)
 	addr := s.ip + ":" + strconv.Itoa(s.port)
-	pool := cache.NewHTTPPool(addr)
+	self := "http://" + addr
+	pool := cache.NewHTTPPoolWithClient(self, cache.NewHTTPClient(s.http.Options()))
+	if len(s.peers) > 0 {
+		if !slices.Contains(s.peers, self) {
+			s.peers = append(s.peers, self)
+		}
+		pool.Set(s.peers...)
+		pool.StartHealthCheck(context.Background(), time.Second)
+		cache.RegisterPeerPicker(pool)
+	}
 	wg := sync.WaitGroup{}
-	//加载组文件
+	//加载组文件，组文件中可以单独为每个组开启或关闭持久化，因此总是加载持久化文件
 	cache.LoadGroups()
-	if s.persistence {
-		cache.LoadPersistence()
-		go s.savePersistence(&wg)
+	//应用组目录，并从其他节点拉取在本节点离线期间创建、修改或删除的组
+	if err := cache.LoadCatalog(); err != nil {
+		log.Println(err)
 	}
+	if s.raft {
+		dir := s.dataDir
+		if dir == "" {
+			dir = "."
+		}
+		if err := pool.StartRaft(dir, s.peers); err != nil {
+			panic(err)
+		}
+	} else if len(s.peers) > 1 {
+		pool.SyncCatalog()
+		pool.StartCatalogSync(context.Background(), 10*time.Second)
+	}
+	loaded := cache.LoadPersistence()
+	if s.replicaOf != "" {
+		pool.StartReplica(s.replicaOf)
+	}
+	if len(s.peers) > 1 {
+		//加载离线之前没有重放完的提示
+		if err := cache.SetHintedHandoff(time.Duration(s.hintMaxAge)*time.Second, s.hintMaxBytes); err != nil {
+			log.Println(err)
+		}
+		pool.StartHintedHandoff(context.Background(), time.Second)
+		pool.StartInvalidation(context.Background())
+	}
+	if s.antiEntropy > 0 && len(s.peers) > 1 {
+		pool.StartAntiEntropy(context.Background(), time.Duration(s.antiEntropy)*time.Second)
+	}
+	go s.savePersistence(&wg)
 	go ListenSignal(&wg)
-	log.Fatal(http.ListenAndServe(addr, pool))
+	if s.warmStart && !loaded && len(s.peers) > 1 {
+		//预热完成之前节点处于未就绪状态
+		pool.StartWarmStart()
+	} else {
+		pool.SetReady(true)
+	}
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   pool,
+		Protocols: cache.ServerProtocols(s.http.H2C),
+	}
+	log.Fatal(server.ListenAndServe())
 }
 
-// 进行持久化工作
+// 进行持久化工作，每个组按照各自的间隔进行保存
 func (s *Server) savePersistence(wg *sync.WaitGroup) {
 	c := make(chan os.Signal)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	wg.Add(1)
 	defer wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-c:
-			//当程序退出时再进行一次保存
+			//当程序退出时将写队列中的数据写入数据源，并再进行一次保存
+			cache.FlushWrites()
 			cache.SavePersistence()
 			return
-		case <-time.After(time.Second * time.Duration(s.persistenceTime)):
-			//当倒计时结束时进行一次保存
-			cache.SavePersistence()
+		case <-ticker.C:
+			//保存到达持久化间隔的组
+			if err := cache.SavePersistenceIfDue(); err != nil {
+				fmt.Println(err)
+			}
 		}
 	}
 }
@@ -1,12 +1,22 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
 
 // 表示正在进行中，或者已经结束的请求，使用waitgroup锁避免重入
 type call struct {
 	wg  sync.WaitGroup
 	val interface{}
 	err error
+
+	dups  int             //共享这次调用的其他调用者的数量
+	chans []chan<- Result //通过DoChan等待结果的调用者
 }
 
 // Group 管理不同key的call
@@ -15,25 +25,128 @@ type Group struct {
 	m  map[string]*call
 }
 
-// Do 针对相同的key，无论Do被调用多少次，函数fn只会被调用一次，等待fn调用完毕，返回返回值或错误
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// Result DoChan返回的结果，Shared表示结果是否被多个调用者共享
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool
+}
+
+// PanicError fn发生panic时，所有等待者收到的错误，包括panic的值与发生panic时的调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: panic: %v\n\n%s", p.Value, p.Stack)
+}
+
+// fn调用了runtime.Goexit时，等待者收到的错误
+var errGoexit = errors.New("singleflight: runtime.Goexit was called")
+
+// Do 针对相同的key，无论Do被调用多少次，函数fn只会被调用一次，等待fn调用完毕，返回返回值或错误，
+// shared表示结果是否被多个调用者共享，fn发生panic时所有通过Do等待的调用者都会panic
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
 		c.wg.Wait()
-		return c.val, c.err
+		if e, ok := c.err.(*PanicError); ok {
+			panic(e)
+		}
+		if c.err == errGoexit {
+			runtime.Goexit()
+		}
+		return c.val, c.err, true
 	}
 	c := new(call)
 	c.wg.Add(1)
 	g.m[key] = c
 	g.mu.Unlock()
-	c.val, c.err = fn()
-	c.wg.Done()
+	g.doCall(c, key, fn)
+	if e, ok := c.err.(*PanicError); ok {
+		panic(e)
+	}
+	return c.val, c.err, c.dups > 0
+}
+
+// DoChan 与Do相同，但是不会阻塞，结果通过返回的channel发送，fn在新的goroutine中调用，
+// fn发生panic时Result.Err为*PanicError
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{chans: []chan<- Result{ch}}
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// DoContext 与Do相同，但是ctx被取消时立即返回ctx.Err()，不会中止fn，也不会影响其他等待者
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	select {
+	case r := <-g.DoChan(key, fn):
+		if e, ok := r.Err.(*PanicError); ok {
+			panic(e)
+		}
+		return r.Val, r.Err, r.Shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), false
+	}
+}
+
+// Forget 忘记key对应的正在进行中的调用，之后对该key的调用会重新调用fn，而不是等待正在进行中的调用
+func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.m, key)
 	g.mu.Unlock()
-	return c.val, c.err
+}
+
+// 调用fn，无论fn正常返回、panic还是调用了runtime.Goexit，都会唤醒所有等待者并删除对应的call
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
+	defer func() {
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c.wg.Done()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		for _, ch := range c.chans {
+			ch <- Result{Val: c.val, Err: c.err, Shared: c.dups > 0}
+		}
+	}()
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					c.err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+	if !normalReturn {
+		recovered = true
+	}
 }
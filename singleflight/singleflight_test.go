@@ -0,0 +1,137 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	g := Group{}
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v.(string) != "bar" || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v", v, err, shared)
+	}
+}
+
+func TestDoDupSuppress(t *testing.T) {
+	g := Group{}
+	release := make(chan struct{})
+	calls := int32(0)
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+	wg := sync.WaitGroup{}
+	sharedCount := int32(0)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("key", fn)
+			if v.(string) != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+			if shared {
+				atomic.AddInt32(&sharedCount, 1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("fn called %d times", calls)
+	}
+	if sharedCount != 10 {
+		t.Fatalf("expect all results shared, got %d", sharedCount)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	g := Group{}
+	r := <-g.DoChan("key", func() (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	if r.Err == nil || r.Err.Error() != "failed" || r.Shared {
+		t.Fatalf("DoChan = %+v", r)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	g := Group{}
+	release := make(chan struct{})
+	waiter := make(chan interface{})
+	go func() {
+		defer func() {
+			waiter <- recover()
+		}()
+		<-release
+		g.Do("key", func() (interface{}, error) {
+			return "unused", nil
+		})
+	}()
+	ch := g.DoChan("key", func() (interface{}, error) {
+		close(release)
+		time.Sleep(20 * time.Millisecond)
+		panic("boom")
+	})
+	if r := <-ch; r.Err == nil {
+		t.Fatalf("expect panic error from DoChan")
+	} else if _, ok := r.Err.(*PanicError); !ok {
+		t.Fatalf("expect *PanicError, got %T", r.Err)
+	}
+	if p := <-waiter; p == nil {
+		t.Fatalf("waiter did not panic")
+	}
+	// panic之后key被删除，新的调用不会阻塞
+	if v, _, _ := g.Do("key", func() (interface{}, error) { return "ok", nil }); v != "ok" {
+		t.Fatalf("Do after panic = %v", v)
+	}
+}
+
+func TestForget(t *testing.T) {
+	g := Group{}
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	if v.(int) != 2 || shared {
+		t.Fatalf("Do after Forget = %v, %v", v, shared)
+	}
+	close(release)
+	if r := <-first; r.Val.(int) != 1 {
+		t.Fatalf("forgotten call = %+v", r)
+	}
+}
+
+func TestDoContext(t *testing.T) {
+	g := Group{}
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+	ch := g.DoChan("key", fn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err, _ := g.DoContext(ctx, "key", fn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	// 调用者取消不会影响其他等待者
+	close(release)
+	if r := <-ch; r.Val.(string) != "bar" || !r.Shared {
+		t.Fatalf("DoChan = %+v", r)
+	}
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt 通过原子操作进行读写的int64
+type AtomicInt int64
+
+// Add 原子地将n加到i上
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 原子地读取i的值
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats 组的统计信息
+type Stats struct {
+	Gets          AtomicInt // 所有的Get请求，包括来自其他节点的请求
+	CacheHits     AtomicInt // 内存或磁盘缓存命中的次数
+	Loads         AtomicInt // 缓存未命中需要加载的次数，等于Gets - CacheHits
+	LoadsDeduped  AtomicInt // 经过singleflight去重之后实际进行加载的次数
+	PeerLoads     AtomicInt // 从远程节点加载成功的次数
+	PeerErrors    AtomicInt // 从远程节点加载失败的次数
+	LocalLoads    AtomicInt // 通过getter从本地加载成功的次数
+	LocalLoadErrs AtomicInt // 通过getter从本地加载失败的次数
+	Evictions     AtomicInt // 内存中的数据被淘汰的次数
+	NegativeHits  AtomicInt // 负缓存命中，直接返回不存在的次数
+	StaleHits     AtomicInt // 命中已经软过期的数据的次数
+	Refreshes     AtomicInt // 在后台刷新数据的次数
+	Writes        AtomicInt // 写入或删除数据源成功的次数
+	WriteErrors   AtomicInt // 写入或删除数据源失败的次数，包括write-behind模式下的每次重试
+	WritesDropped AtomicInt // write-behind模式下重试多次仍然失败而放弃的写入次数
+	Hedges        AtomicInt // 远程节点响应过慢而发送对冲请求的次数
+	PeerRetries   AtomicInt // 请求远程节点失败之后重试的次数
+	ReadRepairs   AtomicInt // 多副本读取时修复版本落后的副本的次数
+}
+
+// StatsSnapshot 某一时刻组的统计信息，包括计数器以及当前内存的使用情况
+type StatsSnapshot struct {
+	Name          string
+	Gets          int64
+	CacheHits     int64
+	Loads         int64
+	LoadsDeduped  int64
+	PeerLoads     int64
+	PeerErrors    int64
+	LocalLoads    int64
+	LocalLoadErrs int64
+	Evictions     int64
+	NegativeHits  int64
+	StaleHits     int64
+	Refreshes     int64
+	Writes        int64
+	WriteErrors   int64
+	WritesDropped int64
+	PendingWrites int64 // write-behind模式下等待写入数据源的键的数量
+	Hedges        int64
+	PeerRetries   int64
+	ReadRepairs   int64
+	Bytes         int64 // 内存中数据占用的字节数
+	Items         int64 // 内存中的键值对数量
+}
+
+// GetStats 获得组当前的统计信息
+func (g *Group) GetStats() StatsSnapshot {
+	bytes, items := g.mainCache.stats()
+	return StatsSnapshot{
+		Name:          g.name,
+		Gets:          g.Stats.Gets.Get(),
+		CacheHits:     g.Stats.CacheHits.Get(),
+		Loads:         g.Stats.Loads.Get(),
+		LoadsDeduped:  g.Stats.LoadsDeduped.Get(),
+		PeerLoads:     g.Stats.PeerLoads.Get(),
+		PeerErrors:    g.Stats.PeerErrors.Get(),
+		LocalLoads:    g.Stats.LocalLoads.Get(),
+		LocalLoadErrs: g.Stats.LocalLoadErrs.Get(),
+		Evictions:     g.Stats.Evictions.Get(),
+		NegativeHits:  g.Stats.NegativeHits.Get(),
+		StaleHits:     g.Stats.StaleHits.Get(),
+		Refreshes:     g.Stats.Refreshes.Get(),
+		Writes:        g.Stats.Writes.Get(),
+		WriteErrors:   g.Stats.WriteErrors.Get(),
+		WritesDropped: g.Stats.WritesDropped.Get(),
+		PendingWrites: g.pendingWrites(),
+		Hedges:        g.Stats.Hedges.Get(),
+		PeerRetries:   g.Stats.PeerRetries.Get(),
+		ReadRepairs:   g.Stats.ReadRepairs.Get(),
+		Bytes:         bytes,
+		Items:         items,
+	}
+}
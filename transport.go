@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+//节点之间以及客户端使用的http客户端，默认的http.Transport对每个节点只保留2个空闲连接，
+//并发较高时会不断地新建连接，因此使用可以配置连接池大小与超时时间的客户端
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultDialTimeout         = 5 * time.Second
+)
+
+// HTTPOptions http客户端的配置，为0的项使用默认值
+type HTTPOptions struct {
+	MaxIdleConns        int           // 所有节点总共的最大空闲连接数
+	MaxIdleConnsPerHost int           // 每个节点的最大空闲连接数
+	MaxConnsPerHost     int           // 每个节点的最大连接数，0表示不限制
+	IdleConnTimeout     time.Duration // 空闲连接被关闭之前的最长空闲时间
+	Timeout             time.Duration // 单个请求的超时时间，包括读取响应，0表示不限制
+	H2C                 bool          // 是否使用明文的HTTP/2，服务端也需要开启
+}
+
+// NewHTTPClient 根据配置创建一个http客户端
+func NewHTTPClient(opts HTTPOptions) *http.Client {
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = defaultMaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        opts.MaxIdleConns,
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     opts.MaxConnsPerHost,
+		IdleConnTimeout:     opts.IdleConnTimeout,
+	}
+	if opts.H2C {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return &http.Client{Transport: t, Timeout: opts.Timeout}
+}
+
+// ServerProtocols 服务端支持的协议，开启h2c时同时支持HTTP/1与明文的HTTP/2
+func ServerProtocols(h2c bool) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetUnencryptedHTTP2(h2c)
+	return p
+}
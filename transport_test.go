@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"cache/cachepb/cachepb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 启动一个服务于pool的测试服务器，返回新建连接的计数
+func countingServer(t *testing.T, h2c bool) (*httptest.Server, *int32) {
+	conns := int32(0)
+	s := httptest.NewUnstartedServer(NewHTTPPool("http://test"))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	s.Config.Protocols = ServerProtocols(h2c)
+	s.Start()
+	t.Cleanup(s.Close)
+	return s, &conns
+}
+
+func TestKeepAliveReuse(t *testing.T) {
+	g := NewGroup("keepalive-test", 2048, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer DeleteGroup(g.name)
+	s, conns := countingServer(t, false)
+	const workers = 16
+	getter := &HttpGetter{BaseURL: s.URL, Client: NewHTTPClient(HTTPOptions{MaxIdleConnsPerHost: workers})}
+	// 每一轮并发发送workers个请求，默认的客户端每一轮结束时只保留2个空闲连接，下一轮需要重新建立连接
+	for range 20 {
+		wg := sync.WaitGroup{}
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				out := &cachepb.Response{}
+				if err := getter.Get(&cachepb.GetRequest{Group: g.name, Key: "k"}, out); err != nil || string(out.Value) != "k" {
+					t.Errorf("get failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	// 所有请求只使用不超过并发数的连接
+	if n := atomic.LoadInt32(conns); n > workers {
+		t.Fatalf("expect at most %d connections, got %d", workers, n)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	s, _ := countingServer(t, true)
+	client := NewHTTPClient(HTTPOptions{H2C: true})
+	res, err := client.Get(s.URL + "/Ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("expect HTTP/2, got %s", res.Proto)
+	}
+}
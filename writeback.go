@@ -0,0 +1,359 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+//将组中数据的写入与删除同步到数据源，getter实现了Setter或Deleter时开启，
+//write-through模式下先写入数据源，成功之后再更新缓存；write-behind模式下先更新缓存，
+//再由后台的写队列按批写入数据源，写入失败时按照指数退避进行重试，setter实现了BatchSetter时一批数据通过一次调用写入
+
+const (
+	// WriteThrough 同步写入数据源
+	WriteThrough = "write-through"
+	// WriteBehind 通过写队列异步写入数据源
+	WriteBehind = "write-behind"
+
+	defaultWriteInterval = 100 * time.Millisecond
+	defaultWriteBatch    = 100
+	// 写入失败时最多重试的次数，超过之后放弃该次写入
+	maxWriteRetries = 5
+	maxWriteBackoff = 30 * time.Second
+)
+
+// Setter 将数据写入数据源
+type Setter interface {
+	Set(key string, value []byte) error
+}
+
+// BatchSetter 支持一次写入多个键的Setter，write-behind模式下写队列取出的一批数据通过一次调用写入，
+// 返回错误时这一批中的所有键都会重试
+type BatchSetter interface {
+	Setter
+	SetBatch(keys []string, values [][]byte) error
+}
+
+// SetterFunc 接口型函数
+type SetterFunc func(key string, value []byte) error
+
+func (f SetterFunc) Set(key string, value []byte) error {
+	return f(key, value)
+}
+
+// Deleter 从数据源中删除数据
+type Deleter interface {
+	Delete(key string) error
+}
+
+// DeleterFunc 接口型函数
+type DeleterFunc func(key string) error
+
+func (f DeleterFunc) Delete(key string) error {
+	return f(key)
+}
+
+// 写队列中的一次写入，同一个键的多次写入会合并为最后一次
+type writeOp struct {
+	value    []byte
+	del      bool
+	attempts int       // 已经失败的次数
+	next     time.Time // 重试时下一次可以写入的时间
+}
+
+// 后台的写队列
+type writeQueue struct {
+	setter   Setter
+	deleter  Deleter
+	interval time.Duration
+	batch    int
+	stats    *Stats
+
+	mu       sync.Mutex
+	ops      map[string]*writeOp // 等待写入的键
+	order    []string            // 等待写入的键按照加入队列的顺序排列
+	inflight map[string]*writeOp // 正在写入的键
+	kick     chan struct{}       // 等待写入的键达到batch时立即写入
+	closed   bool                // 队列关闭之后不再接受新的写入
+	closing  chan struct{}
+	done     chan struct{}
+}
+
+func newWriteQueue(setter Setter, deleter Deleter, interval time.Duration, batch int, stats *Stats) *writeQueue {
+	if interval <= 0 {
+		interval = defaultWriteInterval
+	}
+	if batch <= 0 {
+		batch = defaultWriteBatch
+	}
+	q := &writeQueue{
+		setter:   setter,
+		deleter:  deleter,
+		interval: interval,
+		batch:    batch,
+		stats:    stats,
+		ops:      make(map[string]*writeOp),
+		inflight: make(map[string]*writeOp),
+		kick:     make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// 将一次写入加入队列，覆盖该键之前还未写入的值，队列已经关闭时返回false，由调用者直接写入数据源
+func (q *writeQueue) add(key string, value []byte, del bool) bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	if op, ok := q.ops[key]; ok {
+		op.value, op.del, op.attempts, op.next = value, del, 0, time.Time{}
+	} else {
+		q.ops[key] = &writeOp{value: value, del: del}
+		q.order = append(q.order, key)
+	}
+	full := len(q.ops) >= q.batch
+	q.mu.Unlock()
+	if full {
+		select {
+		case q.kick <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// 获得键还未写入数据源的值，用于在写入完成之前从数据源加载时返回最新的数据
+func (q *writeQueue) pending(key string) (op writeOp, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, ok := q.ops[key]
+	if !ok {
+		p, ok = q.inflight[key]
+	}
+	if ok {
+		op = *p
+	}
+	return
+}
+
+// 等待写入的键的数量
+func (q *writeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ops) + len(q.inflight)
+}
+
+func (q *writeQueue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.kick:
+		case <-q.closing:
+			q.flush(true)
+			return
+		}
+		q.flush(false)
+	}
+}
+
+// 按批写入所有可以写入的键，force为true时忽略重试的等待时间，并且失败时不再重试
+func (q *writeQueue) flush(force bool) {
+	for {
+		keys, ops := q.take(force)
+		if len(keys) == 0 {
+			return
+		}
+		errs := q.write(keys, ops)
+		for i, key := range keys {
+			q.finish(key, ops[i], errs[i], force)
+		}
+	}
+}
+
+// 从队列中取出一批可以写入的键
+func (q *writeQueue) take(force bool) ([]string, []*writeOp) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, q.batch)
+	ops := make([]*writeOp, 0, q.batch)
+	rest := q.order[:0]
+	for _, key := range q.order {
+		op := q.ops[key]
+		if len(keys) < q.batch && (force || !now.Before(op.next)) {
+			keys = append(keys, key)
+			ops = append(ops, op)
+			delete(q.ops, key)
+			q.inflight[key] = op
+			continue
+		}
+		rest = append(rest, key)
+	}
+	q.order = rest
+	return keys, ops
+}
+
+// 将一批键写入数据源，返回每个键的错误，setter实现了BatchSetter时所有的设置通过一次调用写入
+func (q *writeQueue) write(keys []string, ops []*writeOp) []error {
+	errs := make([]error, len(keys))
+	bs, batched := q.setter.(BatchSetter)
+	var index []int
+	var setKeys []string
+	var setValues [][]byte
+	for i, key := range keys {
+		switch {
+		case ops[i].del:
+			if q.deleter != nil {
+				errs[i] = q.deleter.Delete(key)
+			}
+		case batched:
+			index = append(index, i)
+			setKeys = append(setKeys, key)
+			setValues = append(setValues, ops[i].value)
+		case q.setter != nil:
+			errs[i] = q.setter.Set(key, ops[i].value)
+		}
+	}
+	if len(setKeys) > 0 {
+		err := bs.SetBatch(setKeys, setValues)
+		for _, i := range index {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// 记录一个键的写入结果，失败时重新放入队列等待重试，期间该键有新的写入时放弃旧的写入
+func (q *writeQueue) finish(key string, op *writeOp, err error, force bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inflight, key)
+	if err == nil {
+		q.stats.Writes.Add(1)
+		return
+	}
+	q.stats.WriteErrors.Add(1)
+	op.attempts++
+	if _, ok := q.ops[key]; ok {
+		return
+	}
+	if force || op.attempts > maxWriteRetries {
+		q.stats.WritesDropped.Add(1)
+		log.Printf("[Zcache] drop write of %s after %d attempts: %v", key, op.attempts, err)
+		return
+	}
+	backoff := q.interval << op.attempts
+	if backoff > maxWriteBackoff {
+		backoff = maxWriteBackoff
+	}
+	op.next = time.Now().Add(backoff)
+	q.ops[key] = op
+	q.order = append(q.order, key)
+}
+
+// 写入队列中剩余的数据并停止后台的写入，之后的写入直接写入数据源
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	select {
+	case <-q.closing:
+	default:
+		close(q.closing)
+	}
+	<-q.done
+}
+
+//---------------------------------------------------------------------------------------------------------------------
+
+// 根据getter实现的接口以及组的写入模式开启写入数据源
+func (g *Group) openStore(getter Getter, mode string, interval int64, batch int) {
+	if mode != "" && mode != WriteThrough && mode != WriteBehind {
+		log.Printf("[Zcache] unsupported write mode %q of group %s, use %s instead", mode, g.name, WriteThrough)
+		mode = WriteThrough
+	}
+	g.setter, _ = getter.(Setter)
+	g.deleter, _ = getter.(Deleter)
+	g.writeMode, g.writeInterval, g.writeBatch = mode, interval, batch
+	if mode == WriteBehind && (g.setter != nil || g.deleter != nil) {
+		g.writes = newWriteQueue(g.setter, g.deleter, time.Duration(interval)*time.Millisecond, batch, &g.Stats)
+	}
+}
+
+// 将数据写入数据源，write-behind模式下只放入写队列，写队列已经关闭时同步写入
+func (g *Group) storeSet(key string, value []byte) error {
+	if g.writes != nil && g.writes.add(key, cloneBytes(value), false) {
+		return nil
+	}
+	if g.setter == nil {
+		return nil
+	}
+	if err := g.setter.Set(key, value); err != nil {
+		g.Stats.WriteErrors.Add(1)
+		return fmt.Errorf("write %s to store: %w", key, err)
+	}
+	g.Stats.Writes.Add(1)
+	return nil
+}
+
+// 从数据源中删除数据，write-behind模式下只放入写队列，写队列已经关闭时同步删除
+func (g *Group) storeDelete(key string) error {
+	if g.writes != nil && g.writes.add(key, nil, true) {
+		return nil
+	}
+	if g.deleter == nil {
+		return nil
+	}
+	if err := g.deleter.Delete(key); err != nil {
+		g.Stats.WriteErrors.Add(1)
+		return fmt.Errorf("delete %s from store: %w", key, err)
+	}
+	g.Stats.Writes.Add(1)
+	return nil
+}
+
+// 还未写入数据源的数据，从数据源加载时优先使用，del为true表示该键等待从数据源中删除
+func (g *Group) storePending(key string) (value []byte, del bool, ok bool) {
+	if g.writes == nil {
+		return nil, false, false
+	}
+	op, ok := g.writes.pending(key)
+	return op.value, op.del, ok
+}
+
+// 写入队列中剩余的数据，并停止写队列
+func (g *Group) storeClose() {
+	if g.writes != nil {
+		g.writes.close()
+	}
+}
+
+// 等待写入数据源的数量
+func (g *Group) pendingWrites() int64 {
+	if g.writes == nil {
+		return 0
+	}
+	return int64(g.writes.len())
+}
+
+// FlushWrites 将所有组中还未写入数据源的数据写入数据源，并停止写队列，用于服务器退出之前
+func FlushWrites() {
+	mu.RLock()
+	list := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	mu.RUnlock()
+	for _, g := range list {
+		g.storeClose()
+	}
+}
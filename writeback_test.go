@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 实现了Getter、Setter与Deleter的数据源，fail不为0时前fail次写入失败
+type fakeStore struct {
+	mu     sync.Mutex
+	data   map[string]string
+	writes int
+	fail   int
+}
+
+func (s *fakeStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.data[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *fakeStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("store unavailable")
+	}
+	s.data[key] = string(value)
+	return nil
+}
+
+func (s *fakeStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	delete(s.data, key)
+	return nil
+}
+
+func (s *fakeStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func TestWriteThrough(t *testing.T) {
+	store := &fakeStore{data: map[string]string{}, fail: 1}
+	g := NewGroupWithInfo(GroupInfo{Name: "write-through-test", CacheBytes: 2048}, store)
+	defer DeleteGroup(g.name)
+	// 写入数据源失败时不更新缓存
+	if err := g.Set("k", ByteView{b: []byte("v")}); err == nil {
+		t.Fatalf("expect store error")
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Fatalf("cache updated after failed write")
+	}
+	if err := g.Set("k", ByteView{b: []byte("v")}); err != nil {
+		t.Fatalf("set k failed: %v", err)
+	}
+	if v, ok := store.get("k"); !ok || v != "v" {
+		t.Fatalf("store not written, got %q", v)
+	}
+	if _, err := g.Delete("k"); err != nil {
+		t.Fatalf("delete k failed: %v", err)
+	}
+	if _, ok := store.get("k"); ok {
+		t.Fatalf("store not deleted")
+	}
+	if s := g.GetStats(); s.Writes != 2 || s.WriteErrors != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	store := &fakeStore{data: map[string]string{}, fail: 1}
+	g := NewGroupWithInfo(GroupInfo{Name: "write-behind-test", CacheBytes: 2048, WriteMode: WriteBehind, WriteInterval: 20}, store)
+	defer DeleteGroup(g.name)
+	// 同一个键的多次写入合并为最后一次
+	for _, v := range []string{"1", "2", "3"} {
+		if err := g.Set("k", ByteView{b: []byte(v)}); err != nil {
+			t.Fatalf("set k failed: %v", err)
+		}
+	}
+	if s := g.GetStats(); s.PendingWrites != 1 {
+		t.Fatalf("expect 1 pending write, got %+v", s)
+	}
+	// 写入之前被淘汰的数据从写队列中读取
+	g.mainCache.delete("k")
+	if v, err := g.Get("k"); err != nil || v.String() != "3" {
+		t.Fatalf("expect pending value, got %v %v", v, err)
+	}
+	// 第一次写入失败之后重试
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := store.get("k"); v == "3" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("write not retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s := g.GetStats(); s.Writes != 1 || s.WriteErrors != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestWriteBehindFlush(t *testing.T) {
+	store := &fakeStore{data: map[string]string{}}
+	g := NewGroupWithInfo(GroupInfo{Name: "write-flush-test", CacheBytes: 2048, WriteMode: WriteBehind, WriteInterval: 60000}, store)
+	defer DeleteGroup(g.name)
+	_ = g.Set("a", ByteView{b: []byte("1")})
+	_, _ = g.Delete("b")
+	store.data["b"] = "2"
+	FlushWrites()
+	if v, ok := store.get("a"); !ok || v != "1" {
+		t.Fatalf("a not flushed")
+	}
+	if _, ok := store.get("b"); ok {
+		t.Fatalf("b not deleted")
+	}
+	if n := g.pendingWrites(); n != 0 {
+		t.Fatalf("expect no pending writes, got %d", n)
+	}
+	// 写队列关闭之后的写入直接写入数据源
+	if err := g.Set("c", ByteView{b: []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := store.get("c"); !ok || v != "3" {
+		t.Fatalf("write after flush lost")
+	}
+}
+
+// 支持批量写入的数据源
+type batchStore struct {
+	fakeStore
+	batches int
+}
+
+func (s *batchStore) SetBatch(keys []string, values [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	for i, key := range keys {
+		s.data[key] = string(values[i])
+	}
+	return nil
+}
+
+func TestWriteBehindBatch(t *testing.T) {
+	store := &batchStore{fakeStore: fakeStore{data: map[string]string{}}}
+	g := NewGroupWithInfo(GroupInfo{Name: "write-batch-test", CacheBytes: 2048, WriteMode: WriteBehind, WriteInterval: 60000}, store)
+	defer DeleteGroup(g.name)
+	for _, k := range []string{"a", "b", "c"} {
+		_ = g.Set(k, ByteView{b: []byte(k)})
+	}
+	g.storeClose()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.batches != 1 || store.writes != 0 || len(store.data) != 3 {
+		t.Fatalf("expect 1 batch write, got %d batches, %d single writes, %v", store.batches, store.writes, store.data)
+	}
+}